@@ -0,0 +1,98 @@
+// Command cachebench compares the cache admission policies. It replays a key
+// trace against each policy and reports the hit ratio: every access is a Get,
+// and a miss is followed by a Set, as in service.Get. Throughput of the cache
+// implementations is measured by the benchmarks of package cache. The trace is read from -trace, one
+// access per line, either a plain key or a JSON access log entry whose path
+// is used as the key; without -trace a synthetic trace is generated in which
+// a skewed workload is interrupted by sequential scans over cold keys.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"wb-snilez-l0/internal/cache"
+)
+
+func main() {
+	capacity := flag.Int("capacity", 10000, "cache capacity")
+	keys := flag.Int("keys", 20000, "number of distinct keys of the synthetic trace")
+	trace := flag.String("trace", "", "trace file")
+	accesses := flag.Int("accesses", 1000000, "length of the synthetic trace")
+	flag.Parse()
+
+	var (
+		t   []string
+		err error
+	)
+	if *trace != "" {
+		t, err = readTrace(*trace)
+	} else {
+		t = cache.ScanTrace(*keys, *capacity, *accesses)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	replay(tw, t, *capacity)
+}
+
+func replay(tw *tabwriter.Writer, trace []string, capacity int) {
+	fmt.Fprintf(tw, "policy\taccesses\thit ratio\trejections\n")
+	for _, name := range []string{cache.PolicyLRU, cache.PolicyTinyLFU} {
+		policy, err := cache.PolicyByName[string, struct{}](name)
+		if err != nil {
+			panic(err)
+		}
+		c := cache.NewLRU(capacity, time.Hour, policy)
+		for _, k := range trace {
+			if _, ok := c.Get(k); !ok {
+				c.Set(k, struct{}{})
+			}
+		}
+		st := c.Stats()
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\t%d\n", name, len(trace), hitRatio(st), st.Rejections)
+	}
+}
+
+func hitRatio(st cache.Stats) float64 {
+	return float64(st.Hits) / float64(max(1, st.Hits+st.Misses))
+}
+
+// readTrace loads one key per line; JSON access log lines contribute their path.
+func readTrace(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var t []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var entry struct {
+			Path string `json:"path"`
+		}
+		if line[0] == '{' && json.Unmarshal(line, &entry) == nil {
+			if entry.Path != "" {
+				t = append(t, entry.Path)
+			}
+			continue
+		}
+		t = append(t, string(line))
+	}
+	return t, sc.Err()
+}
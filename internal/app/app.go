@@ -2,8 +2,11 @@ package app
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -12,21 +15,26 @@ import (
 
 	"wb-snilez-l0/internal/cache"
 	"wb-snilez-l0/internal/config"
+	"wb-snilez-l0/internal/health"
 	h "wb-snilez-l0/internal/http"
 	kc "wb-snilez-l0/internal/kafka"
 	"wb-snilez-l0/internal/log"
+	"wb-snilez-l0/internal/metrics"
 	"wb-snilez-l0/internal/model"
 	"wb-snilez-l0/internal/repo"
 	"wb-snilez-l0/internal/service"
 )
 
 type App struct {
-	log  *zap.Logger
-	cfg  *config.Config
-	db   *pgxpool.Pool
-	svc  *service.Service
-	kc   *kc.Consumer
-	http *http.Server
+	log    *zap.Logger
+	cfg    *config.Config
+	db     *pgxpool.Pool
+	cache  cache.Cache[string, *model.Order]
+	svc    *service.Service
+	kc     *kc.Consumer
+	relay  *kc.Relay
+	health *health.Checker
+	http   *http.Server
 }
 
 func New() (*App, error) {
@@ -34,6 +42,7 @@ func New() (*App, error) {
 	if err != nil {
 		return nil, err
 	}
+	zap.ReplaceGlobals(logger)
 	cfg, err := config.Load()
 	if err != nil {
 		return nil, err
@@ -57,38 +66,143 @@ func New() (*App, error) {
 		return nil, err
 	}
 
-	r := repo.New(db)
-	lru := cache.NewLRU[string, *model.Order](cfg.Cache.Capacity, cfg.Cache.TTL)
-	svc := service.New(r, lru)
+	m := metrics.New()
+	m.RegisterPool(db)
 
-	_ = svc.Warmup(ctx, cfg.Cache.Capacity)
+	r := repo.New(db, m)
+	policy, err := cache.PolicyByName[string, *model.Order](cfg.Cache.Policy)
+	if err != nil {
+		return nil, err
+	}
+	opts := []cache.Option[string, *model.Order]{
+		policy,
+		cache.WithJanitor[string, *model.Order](cfg.Cache.SweepInterval),
+		cache.WithGrace[string, *model.Order](cfg.Cache.StaleGrace),
+	}
+	if cfg.Cache.MaxBytes > 0 {
+		opts = append(opts, cache.WithCost(func(_ string, o *model.Order) int64 { return o.Size() }, cfg.Cache.MaxBytes))
+	}
+	oc := cache.New(cfg.Cache.Capacity, cfg.Cache.TTL, cfg.Cache.Shards, opts...)
+	m.RegisterCache("orders", oc.Stats)
+	svc := service.New(r, oc, m, service.Config{
+		TTL:          cfg.Cache.TTL,
+		NegativeTTL:  cfg.Cache.NegativeTTL,
+		RefreshAhead: cfg.Cache.RefreshAhead,
+
+		WarmupChunk:   cfg.Cache.Warmup.ChunkSize,
+		ReadyFraction: cfg.Cache.Warmup.ReadyFraction,
+	})
+	m.RegisterCache("orders_by_track", svc.TrackIndexStats)
+	m.RegisterCache("orders_by_transaction", svc.TransactionIndexStats)
+	m.RegisterCache("orders_negative", svc.NegativeStats)
+	m.GaugeFunc("cache", "warmup_progress", "Share of the cache warmup target loaded; 1 once warm.", svc.WarmupProgress)
+
+	k := kc.New(kc.Config{
+		Brokers:        cfg.Kafka.Brokers,
+		Topic:          cfg.Kafka.Topic,
+		GroupID:        cfg.Kafka.GroupID,
+		MinBytes:       cfg.Kafka.MinBytes,
+		MaxBytes:       cfg.Kafka.MaxBytes,
+		CommitInterval: cfg.Kafka.CommitInterval,
+
+		DLQTopic:        cfg.Kafka.DLQ.Topic,
+		DLQWriteTimeout: cfg.Kafka.DLQ.WriteTimeout,
+
+		Retry: kc.RetryPolicy{
+			MaxAttempts:    cfg.Kafka.Retry.MaxAttempts,
+			InitialBackoff: cfg.Kafka.Retry.InitialBackoff,
+			MaxBackoff:     cfg.Kafka.Retry.MaxBackoff,
+			Multiplier:     cfg.Kafka.Retry.Multiplier,
+			Jitter:         cfg.Kafka.Retry.Jitter,
+		},
+		ParkingTopic: cfg.Kafka.Retry.ParkingTopic,
+
+		Workers:   cfg.Kafka.Workers,
+		QueueSize: cfg.Kafka.QueueSize,
+		Ordering:  cfg.Kafka.Ordering,
+		BatchSize: cfg.Kafka.Batch.Size,
+		BatchWait: cfg.Kafka.Batch.Wait,
+
+		Dedupe: kc.DedupeConfig{
+			Retention:       cfg.Kafka.Dedupe.Retention,
+			CleanupInterval: cfg.Kafka.Dedupe.CleanupInterval,
+		},
+	}, svc, logger, m)
+
+	var relay *kc.Relay
+	if cfg.Kafka.Outbox.Topic != "" {
+		relay = kc.NewRelay(kc.RelayConfig{
+			Brokers:         cfg.Kafka.Brokers,
+			Topic:           cfg.Kafka.Outbox.Topic,
+			WriteTimeout:    cfg.Kafka.Outbox.WriteTimeout,
+			BatchSize:       cfg.Kafka.Outbox.BatchSize,
+			PollInterval:    cfg.Kafka.Outbox.PollInterval,
+			Retention:       cfg.Kafka.Outbox.Retention,
+			CleanupInterval: cfg.Kafka.Outbox.CleanupInterval,
+		}, r, logger, m)
+	}
+
+	hc := health.New(cfg.Server.CheckTimeout)
+	hc.Add("postgres", svc.Ping)
+	// HTTP reads don't need Kafka, and a rebalance hits every replica at
+	// once; Kafka is reported without taking replicas out of rotation.
+	hc.AddInformational("kafka", k.Ping)
+	hc.AddInformational("kafka_group", k.CheckGroup)
+	hc.Add("cache_warmup", svc.CheckWarm)
 
 	mux := http.NewServeMux()
 	hd := h.NewHandler(svc, logger)
-	mux.HandleFunc("GET /order/", hd.GetOrder)
+	mux.HandleFunc("GET /order/", hd.Wrap(hd.GetOrder))
+	mux.HandleFunc("GET /order/{uid}/history", hd.Wrap(hd.GetOrderHistory))
+	mux.HandleFunc("GET /order/{uid}/history/{version}", hd.Wrap(hd.GetOrderRevision))
+	mux.HandleFunc("GET /orders", hd.Wrap(hd.ListOrders))
+	mux.HandleFunc("POST /orders", hd.Wrap(hd.CreateOrder))
+	mux.HandleFunc("PUT /orders/{uid}", hd.Wrap(hd.UpdateOrder))
+	mux.HandleFunc("DELETE /orders/{uid}", hd.Wrap(hd.DeleteOrder))
+	mux.HandleFunc("GET /orders/by-track/{track}", hd.Wrap(hd.GetOrderByTrack))
+	mux.HandleFunc("GET /orders/by-transaction/{tx}", hd.Wrap(hd.GetOrderByTransaction))
+	mux.HandleFunc("GET /customers/{customer_id}/orders", hd.Wrap(hd.ListCustomerOrders))
+	mux.Handle("GET /metrics", m.Handler())
+	mux.HandleFunc("GET /healthz", hc.Liveness)
+	mux.HandleFunc("GET /readyz", hc.Readiness)
 	if cfg.UI.Enable {
 		fs := http.FileServer(http.Dir(cfg.UI.StaticDir))
-		mux.Handle("/", fs)
+		mux.Handle("GET /", fs)
 	}
 
 	srv := &http.Server{
-		Addr:         cfg.Server.Addr,
-		Handler:      mux,
+		Addr: cfg.Server.Addr,
+		Handler: h.Chain(mux,
+			h.RequestContext(logger),
+			h.AccessLog,
+			h.Instrument(m),
+			h.Recover,
+			h.ErrorEnvelope,
+		),
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
-	k := kc.New(kc.Config{
-		Brokers:        cfg.Kafka.Brokers,
-		Topic:          cfg.Kafka.Topic,
-		GroupID:        cfg.Kafka.GroupID,
-		MinBytes:       cfg.Kafka.MinBytes,
-		MaxBytes:       cfg.Kafka.MaxBytes,
-		CommitInterval: cfg.Kafka.CommitInterval,
-	}, svc, logger)
+	return &App{log: logger, cfg: cfg, db: db, cache: oc, svc: svc, kc: k, relay: relay, health: hc, http: srv}, nil
+}
 
-	return &App{log: logger, cfg: cfg, db: db, svc: svc, kc: k, http: srv}, nil
+// restoreCache loads the cache snapshot if there is a usable one and warms
+// the cache from the database otherwise.
+func restoreCache(ctx context.Context, svc *service.Service, cfg config.Cache) error {
+	if cfg.Snapshot.Path != "" {
+		err := svc.LoadSnapshot(ctx, cfg.Snapshot.Path, cfg.Snapshot.MaxAge)
+		if err == nil {
+			return nil
+		}
+		l := log.FromContext(ctx)
+		if errors.Is(err, os.ErrNotExist) {
+			l.Info("no cache snapshot, warming up from database")
+		} else {
+			l.Warn("cache snapshot unusable, warming up from database", zap.Error(err))
+		}
+	}
+	return svc.Warmup(ctx, cfg.Capacity)
 }
 
 func (a *App) Run() error {
@@ -102,17 +216,54 @@ func (a *App) Run() error {
 		}
 	}()
 
-	go func() {
+	// Background workers use the database; it is closed only after they exit.
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		if err := restoreCache(ctx, a.svc, a.cfg.Cache); err != nil {
+			a.log.Warn("cache warmup failed", zap.Error(err))
+		}
+	})
+
+	if a.cfg.Cache.ListenChanges {
+		wg.Go(func() {
+			if err := a.svc.ListenChanges(ctx); err != nil {
+				a.log.Error("cache invalidation listener", zap.Error(err))
+			}
+		})
+	}
+
+	if a.relay != nil {
+		wg.Go(func() {
+			a.log.Info("outbox relay started")
+			if err := a.relay.Run(ctx); err != nil {
+				a.log.Error("outbox relay", zap.Error(err))
+			}
+		})
+	}
+
+	wg.Go(func() {
 		a.log.Info("kafka consumer started")
 		if err := a.kc.Run(ctx); err != nil {
 			a.log.Error("kafka run", zap.Error(err))
 		}
-	}()
+	})
 
 	<-ctx.Done()
+	a.health.SetDraining(true)
+	a.log.Info("draining", zap.Duration("delay", a.cfg.Server.ShutdownDelay))
+	time.Sleep(a.cfg.Server.ShutdownDelay)
+
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	_ = a.http.Shutdown(shutdownCtx)
+	wg.Wait()
+	a.log.Info("background workers stopped")
+	if path := a.cfg.Cache.Snapshot.Path; path != "" {
+		if err := a.svc.SaveSnapshot(shutdownCtx, path); err != nil {
+			a.log.Warn("cache snapshot save failed", zap.Error(err))
+		}
+	}
+	a.cache.Close()
 	a.db.Close()
 	a.log.Sync()
 	return nil
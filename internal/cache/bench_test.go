@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	benchCapacity = 10000
+	benchKeys     = 20000
+	benchReads    = 90 // percent
+)
+
+func benchmarkCache(b *testing.B, c Cache[string, int]) {
+	names := make([]string, benchKeys)
+	for i := range names {
+		names[i] = "order_" + strconv.Itoa(i)
+	}
+	for i := 0; i < benchCapacity; i++ {
+		c.Set(names[i], i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		z := rand.NewZipf(r, 1.1, 1, uint64(len(names)-1))
+		for pb.Next() {
+			k := names[z.Uint64()]
+			if r.Intn(100) < benchReads {
+				c.Get(k)
+			} else {
+				c.Set(k, 0)
+			}
+		}
+	})
+}
+
+func BenchmarkLRU(b *testing.B) {
+	benchmarkCache(b, NewLRU[string, int](benchCapacity, time.Hour))
+}
+
+func BenchmarkSharded(b *testing.B) {
+	benchmarkCache(b, NewSharded[string, int](benchCapacity, time.Hour, 16))
+}
@@ -3,86 +3,536 @@ package cache
 import (
 	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Cache is the API shared by LRU and Sharded.
+type Cache[K comparable, V any] interface {
+	Get(key K) (V, bool)
+	// GetEntry also returns entries within the grace period after expiry,
+	// with their expiry time.
+	GetEntry(key K) (V, time.Time, bool)
+	Set(key K, value V)
+	// Put is Set bypassing the admission policy, for values written through
+	// that are likely to be read soon.
+	Put(key K, value V)
+	// Add is Set unless the key holds a live entry; it reports whether it
+	// stored value, which it doesn't if the admission policy refuses it.
+	Add(key K, value V) bool
+	Delete(key K)
+	Len() int
+	Stats() Stats
+	// OnEvict registers fn to be called for every entry leaving the cache.
+	OnEvict(fn func(key K, value V, reason EvictReason))
+	// Entries and Restore copy the live entries out and back in, e.g. to
+	// persist the cache across restarts.
+	Entries() []Entry[K, V]
+	Restore(entries []Entry[K, V])
+	// Close stops background work; the cache stays usable.
+	Close()
+}
+
+// Stats is a snapshot of the cache counters. Counters are cumulative.
+type Stats struct {
+	Hits uint64
+	// StaleHits counts expired entries served by GetEntry within the grace
+	// period; they are counted neither as hits nor as misses.
+	StaleHits   uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+	// Deletions and Replacements count entries removed by Delete and
+	// overwritten by Set.
+	Deletions    uint64
+	Replacements uint64
+	// Rejections counts new entries refused by the admission policy or for
+	// exceeding the cost budget on their own.
+	Rejections uint64
+	Size       int
+	Capacity   int
+	// Cost and MaxCost are the summed entry cost and its budget; both are
+	// zero unless the cache was created WithCost.
+	Cost    int64
+	MaxCost int64
+}
+
+// EvictReason tells why an entry left the cache.
+type EvictReason int
+
+const (
+	// EvictCapacity: dropped to stay within capacity or cost budget.
+	EvictCapacity EvictReason = iota
+	// EvictExpired: its TTL passed.
+	EvictExpired
+	// EvictDeleted: removed by Delete.
+	EvictDeleted
+	// EvictReplaced: overwritten by Set with the same key.
+	EvictReplaced
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	case EvictReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// Option configures an LRU.
+type Option[K comparable, V any] func(*options[K, V])
+
+type options[K comparable, V any] struct {
+	cost          func(K, V) int64
+	maxCost       int64
+	sweepInterval time.Duration
+	policy        func(capacity int) Policy[K]
+	grace         time.Duration
+}
+
+// WithCost bounds the cache by the summed cost of its entries in addition to
+// the entry count. cost is called once per Set, so it should be cheap; a
+// value costing more than maxCost on its own is not stored.
+func WithCost[K comparable, V any](cost func(K, V) int64, maxCost int64) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.cost = cost
+		o.maxCost = maxCost
+	}
+}
+
+// WithJanitor removes expired entries every interval instead of leaving them
+// until they are looked up or pushed out. Stop it with Close.
+func WithJanitor[K comparable, V any](interval time.Duration) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.sweepInterval = interval
+	}
+}
+
+// WithGrace keeps entries for grace after their TTL so that GetEntry can
+// still serve them as stale. Get treats them as expired either way.
+func WithGrace[K comparable, V any](grace time.Duration) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.grace = grace
+	}
+}
+
 type entry[K comparable, V any] struct {
 	key       K
 	value     V
+	cost      int64
 	expiresAt time.Time
 }
 
+// eviction is an entry removed under the lock whose callbacks still have to
+// run once the lock is released.
+type eviction[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
+}
+
+// promoteBuffer bounds the recency updates an LRU queues between writes.
+const promoteBuffer = 64
+
+// LRU is a size-bounded cache with per-entry TTL.
+//
+// Reads only take the read lock. Instead of moving the entry to the front
+// right away, Get queues it in a bounded buffer that is applied under the
+// write lock by the next write, or by a reader that finds the buffer full and
+// the lock free. Updates that don't fit are dropped: recency is approximate
+// under heavy read load, capacity and TTL are exact.
 type LRU[K comparable, V any] struct {
 	mu       sync.RWMutex
 	capacity int
 	ttl      time.Duration
+	grace    time.Duration
 	ll       *list.List
 	table    map[K]*list.Element
+	promote  chan *list.Element
+	onEvict  []func(K, V, EvictReason)
+
+	costOf  func(K, V) int64
+	maxCost int64
+	cost    int64
+	policy  Policy[K]
+
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	hits         atomic.Uint64
+	staleHits    atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+	expirations  atomic.Uint64
+	deletions    atomic.Uint64
+	replacements atomic.Uint64
+	rejections   atomic.Uint64
+}
+
+func NewLRU[K comparable, V any](capacity int, ttl time.Duration, opts ...Option[K, V]) *LRU[K, V] {
+	var o options[K, V]
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newLRU(capacity, ttl, o)
 }
 
-func NewLRU[K comparable, V any](capacity int, ttl time.Duration) *LRU[K, V] {
+func newLRU[K comparable, V any](capacity int, ttl time.Duration, o options[K, V]) *LRU[K, V] {
 	if capacity <= 0 {
 		capacity = 1
 	}
-	return &LRU[K, V]{
+	c := &LRU[K, V]{
 		capacity: capacity,
 		ttl:      ttl,
+		grace:    max(o.grace, 0),
 		ll:       list.New(),
 		table:    make(map[K]*list.Element, capacity),
+		promote:  make(chan *list.Element, promoteBuffer),
+		stop:     make(chan struct{}),
 	}
+	if o.cost != nil && o.maxCost > 0 {
+		c.costOf = o.cost
+		c.maxCost = o.maxCost
+	}
+	if o.policy != nil {
+		c.policy = o.policy(capacity)
+	}
+	if o.sweepInterval > 0 {
+		go janitor(o.sweepInterval, c.stop, c.sweep)
+	}
+	return c
+}
+
+// OnEvict registers fn to be called, outside the cache lock, for every entry
+// that leaves the cache.
+func (c *LRU[K, V]) OnEvict(fn func(key K, value V, reason EvictReason)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvict = append(c.onEvict, fn)
 }
 
 func (c *LRU[K, V]) Get(key K) (V, bool) {
+	v, _, ok := c.lookup(key, false)
+	return v, ok
+}
+
+// GetEntry is Get for callers that handle staleness themselves: it also
+// returns entries that expired less than the grace period ago, together with
+// the expiry time so the caller can tell fresh from stale.
+func (c *LRU[K, V]) GetEntry(key K) (V, time.Time, bool) {
+	return c.lookup(key, true)
+}
+
+func (c *LRU[K, V]) lookup(key K, stale bool) (V, time.Time, bool) {
+	// Plain LRU has no policy and records nothing.
+	if c.policy != nil {
+		c.policy.Record(key)
+	}
+	var zero V
 	c.mu.RLock()
 	elem, ok := c.table[key]
 	if !ok {
 		c.mu.RUnlock()
-		var zero V
-		return zero, false
+		c.misses.Add(1)
+		return zero, time.Time{}, false
 	}
 	ent := elem.Value.(entry[K, V])
-	if time.Now().After(ent.expiresAt) {
-		c.mu.RUnlock()
-		c.Delete(key)
-		var zero V
-		return zero, false
-	}
 	c.mu.RUnlock()
+
+	now := time.Now()
+	if now.After(ent.expiresAt) {
+		if now.After(ent.expiresAt.Add(c.grace)) {
+			c.expire(elem)
+			c.misses.Add(1)
+			return zero, time.Time{}, false
+		}
+		if !stale {
+			c.misses.Add(1)
+			return zero, time.Time{}, false
+		}
+		c.staleHits.Add(1)
+	} else {
+		c.hits.Add(1)
+	}
+	c.touch(elem)
+	return ent.value, ent.expiresAt, true
+}
+
+// peek returns the live value of key without touching recency or counters.
+func (c *LRU[K, V]) peek(key K) (V, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if elem, ok := c.table[key]; ok {
+		if ent := elem.Value.(entry[K, V]); !time.Now().After(ent.expiresAt) {
+			return ent.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// touch queues elem for promotion to the front.
+func (c *LRU[K, V]) touch(elem *list.Element) {
+	select {
+	case c.promote <- elem:
+		return
+	default:
+	}
+	if c.mu.TryLock() {
+		c.drain()
+		c.ll.MoveToFront(elem)
+		c.mu.Unlock()
+	}
+}
+
+// drain applies queued promotions. It must be called with c.mu held.
+// Elements removed from the list meanwhile are ignored by MoveToFront.
+func (c *LRU[K, V]) drain() {
+	for {
+		select {
+		case elem := <-c.promote:
+			c.ll.MoveToFront(elem)
+		default:
+			return
+		}
+	}
+}
+
+// expire removes elem if it is still cached and past its grace period; a
+// concurrent Set may have refreshed it since the caller looked.
+func (c *LRU[K, V]) expire(elem *list.Element) {
 	c.mu.Lock()
-	c.ll.MoveToFront(elem)
+	var ev []eviction[K, V]
+	ent := elem.Value.(entry[K, V])
+	if c.table[ent.key] == elem && time.Now().After(ent.expiresAt.Add(c.grace)) {
+		ev = c.remove(ev, elem, EvictExpired)
+	}
 	c.mu.Unlock()
-	return ent.value, true
+	c.notify(ev)
 }
 
 func (c *LRU[K, V]) Set(key K, value V) {
+	c.store(key, value, c.policy != nil)
+}
+
+func (c *LRU[K, V]) Put(key K, value V) {
+	c.store(key, value, false)
+}
+
+func (c *LRU[K, V]) store(key K, value V, usePolicy bool) {
+	c.mu.Lock()
+	c.drain()
+	ev, _ := c.set(nil, key, value, time.Now().Add(c.ttl), usePolicy)
+	c.mu.Unlock()
+	c.notify(ev)
+}
+
+// Add stores value unless key already holds a live entry, and reports
+// whether it did. It lets bulk loads fill the cache without overwriting
+// entries written meanwhile.
+func (c *LRU[K, V]) Add(key K, value V) bool {
+	c.mu.Lock()
+	c.drain()
+	if elem, ok := c.table[key]; ok && !time.Now().After(elem.Value.(entry[K, V]).expiresAt) {
+		c.mu.Unlock()
+		return false
+	}
+	ev, stored := c.set(nil, key, value, time.Now().Add(c.ttl), c.policy != nil)
+	c.mu.Unlock()
+	c.notify(ev)
+	return stored
+}
+
+// Entry is a cached value with its expiry, as carried by snapshots.
+type Entry[K comparable, V any] struct {
+	Key       K
+	Value     V
+	ExpiresAt time.Time
+}
+
+// Entries returns the entries that are still within their grace period, most
+// recently used first.
+func (c *LRU[K, V]) Entries() []Entry[K, V] {
+	now := time.Now()
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.drain()
+	res := make([]Entry[K, V], 0, c.ll.Len())
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		ent := elem.Value.(entry[K, V])
+		if !now.After(ent.expiresAt.Add(c.grace)) {
+			res = append(res, Entry[K, V]{Key: ent.key, Value: ent.value, ExpiresAt: ent.expiresAt})
+		}
+	}
+	return res
+}
 
+// Restore adds entries, most recently used first as returned by Entries,
+// keeping their expiry. Entries past their grace period are skipped, and the
+// admission policy is bypassed: it has no history to judge them by.
+func (c *LRU[K, V]) Restore(entries []Entry[K, V]) {
+	now := time.Now()
+	c.mu.Lock()
+	c.drain()
+	var ev []eviction[K, V]
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if !now.After(e.ExpiresAt.Add(c.grace)) {
+			ev, _ = c.set(ev, e.Key, e.Value, e.ExpiresAt, false)
+		}
+	}
+	c.mu.Unlock()
+	c.notify(ev)
+}
+
+// set stores the entry, asking the admission policy first if usePolicy is
+// set, and reports whether it did. It must be called with c.mu held.
+func (c *LRU[K, V]) set(ev []eviction[K, V], key K, value V, expiresAt time.Time, usePolicy bool) (_ []eviction[K, V], stored bool) {
+	var cost int64
+	if c.costOf != nil {
+		cost = c.costOf(key, value)
+		if cost > c.maxCost {
+			// Too large to cache at all: refuse it, and drop the old value
+			// so that it isn't served in place of the new one.
+			if elem, ok := c.table[key]; ok {
+				ev = c.remove(ev, elem, EvictReplaced)
+			}
+			c.rejections.Add(1)
+			return ev, false
+		}
+	}
+
+	ent := entry[K, V]{key: key, value: value, cost: cost, expiresAt: expiresAt}
 	if elem, ok := c.table[key]; ok {
-		elem.Value = entry[K, V]{key: key, value: value, expiresAt: time.Now().Add(c.ttl)}
+		old := elem.Value.(entry[K, V])
+		c.cost += cost - old.cost
+		elem.Value = ent
 		c.ll.MoveToFront(elem)
-		return
+		c.replacements.Add(1)
+		ev = c.evicted(ev, key, old.value, EvictReplaced)
+	} else {
+		if usePolicy && !c.admit(key, cost) {
+			c.rejections.Add(1)
+			return ev, false
+		}
+		c.table[key] = c.ll.PushFront(ent)
+		c.cost += cost
 	}
 
-	elem := c.ll.PushFront(entry[K, V]{key: key, value: value, expiresAt: time.Now().Add(c.ttl)})
-	c.table[key] = elem
-
-	if c.ll.Len() > c.capacity {
+	for c.ll.Len() > c.capacity || (c.costOf != nil && c.cost > c.maxCost) {
 		oldest := c.ll.Back()
-		if oldest != nil {
-			ent := oldest.Value.(entry[K, V])
-			delete(c.table, ent.key)
-			c.ll.Remove(oldest)
+		if oldest == nil {
+			break
+		}
+		ev = c.remove(ev, oldest, EvictCapacity)
+	}
+	return ev, true
+}
+
+// admit asks the policy whether a new entry may evict the LRU tail. It is
+// always admitted while there is room for it.
+func (c *LRU[K, V]) admit(key K, cost int64) bool {
+	full := c.ll.Len() >= c.capacity || (c.costOf != nil && c.cost+cost > c.maxCost)
+	oldest := c.ll.Back()
+	if !full || oldest == nil {
+		return true
+	}
+	return c.policy.Admit(key, oldest.Value.(entry[K, V]).key)
+}
+
+// remove drops elem from the cache, counts it and appends it to ev for
+// notification. It must be called with c.mu held.
+func (c *LRU[K, V]) remove(ev []eviction[K, V], elem *list.Element, reason EvictReason) []eviction[K, V] {
+	ent := elem.Value.(entry[K, V])
+	delete(c.table, ent.key)
+	c.ll.Remove(elem)
+	c.cost -= ent.cost
+
+	switch reason {
+	case EvictCapacity:
+		c.evictions.Add(1)
+	case EvictExpired:
+		c.expirations.Add(1)
+	case EvictDeleted:
+		c.deletions.Add(1)
+	case EvictReplaced:
+		c.replacements.Add(1)
+	}
+	return c.evicted(ev, ent.key, ent.value, reason)
+}
+
+// evicted appends an eviction to ev if anyone listens for it.
+func (c *LRU[K, V]) evicted(ev []eviction[K, V], key K, value V, reason EvictReason) []eviction[K, V] {
+	if len(c.onEvict) == 0 {
+		return ev
+	}
+	return append(ev, eviction[K, V]{key: key, value: value, reason: reason})
+}
+
+// notify runs the eviction callbacks. It must be called without c.mu held so
+// that callbacks may use the cache.
+func (c *LRU[K, V]) notify(ev []eviction[K, V]) {
+	if len(ev) == 0 {
+		return
+	}
+	c.mu.RLock()
+	fns := c.onEvict
+	c.mu.RUnlock()
+	for _, e := range ev {
+		for _, fn := range fns {
+			fn(e.key, e.value, e.reason)
 		}
 	}
 }
 
 func (c *LRU[K, V]) Delete(key K) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.drain()
+	var ev []eviction[K, V]
 	if elem, ok := c.table[key]; ok {
-		delete(c.table, key)
-		c.ll.Remove(elem)
+		ev = c.remove(ev, elem, EvictDeleted)
+	}
+	c.mu.Unlock()
+	c.notify(ev)
+}
+
+// sweep removes all entries past their expiry and grace period.
+func (c *LRU[K, V]) sweep() {
+	now := time.Now()
+	c.mu.Lock()
+	c.drain()
+	var ev []eviction[K, V]
+	for _, elem := range c.table {
+		if now.After(elem.Value.(entry[K, V]).expiresAt.Add(c.grace)) {
+			ev = c.remove(ev, elem, EvictExpired)
+		}
+	}
+	c.mu.Unlock()
+	c.notify(ev)
+}
+
+// Close stops the janitor, if any.
+func (c *LRU[K, V]) Close() {
+	c.closeOnce.Do(func() { close(c.stop) })
+}
+
+func janitor(interval time.Duration, stop <-chan struct{}, sweep func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			sweep()
+		case <-stop:
+			return
+		}
 	}
 }
 
@@ -91,3 +541,23 @@ func (c *LRU[K, V]) Len() int {
 	defer c.mu.RUnlock()
 	return c.ll.Len()
 }
+
+func (c *LRU[K, V]) Stats() Stats {
+	c.mu.RLock()
+	size, cost := c.ll.Len(), c.cost
+	c.mu.RUnlock()
+	return Stats{
+		Hits:         c.hits.Load(),
+		StaleHits:    c.staleHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Expirations:  c.expirations.Load(),
+		Deletions:    c.deletions.Load(),
+		Replacements: c.replacements.Load(),
+		Rejections:   c.rejections.Load(),
+		Size:         size,
+		Capacity:     c.capacity,
+		Cost:         cost,
+		MaxCost:      c.maxCost,
+	}
+}
@@ -0,0 +1,64 @@
+package cache
+
+import "time"
+
+// Index caches secondary-key lookups over a Cache. It maps a secondary key to
+// the primary key of an entry; the entry itself stays in the primary cache.
+//
+// Mappings are dropped through the primary's eviction hook whenever their
+// entry leaves it, for whatever reason. Lookups still check the primary entry,
+// which covers the short window between an eviction and its callback.
+type Index[K comparable, V any] struct {
+	primary Cache[K, V]
+	keyOf   func(V) string
+	idx     *LRU[string, K]
+}
+
+func NewIndex[K comparable, V any](primary Cache[K, V], keyOf func(V) string, capacity int, ttl time.Duration) *Index[K, V] {
+	ix := &Index[K, V]{
+		primary: primary,
+		keyOf:   keyOf,
+		idx:     NewLRU[string, K](capacity, ttl),
+	}
+	primary.OnEvict(ix.evicted)
+	return ix
+}
+
+// evicted drops the mapping of a value that left the primary cache, unless
+// the secondary key has since been mapped to another entry.
+func (ix *Index[K, V]) evicted(key K, value V, _ EvictReason) {
+	sk := ix.keyOf(value)
+	if sk == "" {
+		return
+	}
+	if k, ok := ix.idx.peek(sk); ok && k == key {
+		ix.idx.Delete(sk)
+	}
+}
+
+// Add indexes value, which must already be stored in the primary cache
+// under key.
+func (ix *Index[K, V]) Add(key K, value V) {
+	if sk := ix.keyOf(value); sk != "" {
+		ix.idx.Set(sk, key)
+	}
+}
+
+// Get returns the primary entry indexed under the secondary key sk.
+func (ix *Index[K, V]) Get(sk string) (V, bool) {
+	var zero V
+	key, ok := ix.idx.Get(sk)
+	if !ok {
+		return zero, false
+	}
+	v, ok := ix.primary.Get(key)
+	if !ok || ix.keyOf(v) != sk {
+		ix.idx.Delete(sk)
+		return zero, false
+	}
+	return v, true
+}
+
+func (ix *Index[K, V]) Stats() Stats {
+	return ix.idx.Stats()
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math/bits"
+	"sync"
+)
+
+// Policy decides whether a new entry may push out the entry the LRU would
+// evict for it. Implementations must be safe for concurrent use.
+type Policy[K comparable] interface {
+	// Record notes an access to key, hit or miss.
+	Record(key K)
+	// Admit reports whether candidate is worth more than victim.
+	Admit(candidate, victim K) bool
+}
+
+// Policy names accepted by PolicyByName.
+const (
+	PolicyLRU     = "lru"
+	PolicyTinyLFU = "tinylfu"
+)
+
+// WithPolicy installs an admission policy; newPolicy is called with the
+// capacity of each LRU, so a Sharded cache gets one policy per shard.
+func WithPolicy[K comparable, V any](newPolicy func(capacity int) Policy[K]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.policy = newPolicy
+	}
+}
+
+// PolicyByName returns the option for a named policy; plain LRU needs none.
+func PolicyByName[K comparable, V any](name string) (Option[K, V], error) {
+	switch name {
+	case "", PolicyLRU:
+		return func(*options[K, V]) {}, nil
+	case PolicyTinyLFU:
+		return WithPolicy[K, V](func(capacity int) Policy[K] { return NewTinyLFU[K](capacity) }), nil
+	default:
+		return nil, fmt.Errorf("unknown cache policy %q", name)
+	}
+}
+
+// TinyLFU admits a new entry only if it was accessed more often than the
+// victim, so one-off lookups don't flush frequently used entries.
+//
+// Frequencies are estimated by a count-min sketch of 4 rows of saturating
+// 4-bit counters, fronted by a doorkeeper bit set that absorbs the first
+// access of every key. After 10 accesses per cached entry all counters are
+// halved and the doorkeeper is cleared, so old popularity fades.
+type TinyLFU[K comparable] struct {
+	mu   sync.Mutex
+	seed maphash.Seed
+
+	table []uint64 // 16 4-bit counters per word
+	mask  uint64   // counters per row - 1
+	door  []uint64
+	dmask uint64
+
+	additions  int
+	sampleSize int
+
+	// pending buffers recorded hashes so that reads don't contend on mu;
+	// see Record.
+	pending chan uint64
+}
+
+const (
+	sketchDepth  = 4
+	recordBuffer = 256
+)
+
+func NewTinyLFU[K comparable](capacity int) *TinyLFU[K] {
+	capacity = max(capacity, 16)
+	width := 1 << bits.Len(uint(capacity-1)) // counters per row, power of two
+	return &TinyLFU[K]{
+		seed:       maphash.MakeSeed(),
+		table:      make([]uint64, sketchDepth*width/16),
+		mask:       uint64(width - 1),
+		door:       make([]uint64, width/8),
+		dmask:      uint64(width*8 - 1),
+		sampleSize: 10 * capacity,
+		pending:    make(chan uint64, recordBuffer),
+	}
+}
+
+// Record queues the access and applies the queue only when it is full, as
+// the LRU does with promotions. If another goroutine holds the sketch then,
+// the access is dropped: the sketch is an estimate anyway.
+func (t *TinyLFU[K]) Record(key K) {
+	h := maphash.Comparable(t.seed, key)
+	select {
+	case t.pending <- h:
+		return
+	default:
+	}
+	if t.mu.TryLock() {
+		t.drain()
+		t.add(h)
+		t.mu.Unlock()
+	}
+}
+
+// drain applies the queued accesses. It must be called with t.mu held.
+func (t *TinyLFU[K]) drain() {
+	for {
+		select {
+		case h := <-t.pending:
+			t.add(h)
+		default:
+			return
+		}
+	}
+}
+
+// add counts an access to hash h. It must be called with t.mu held.
+func (t *TinyLFU[K]) add(h uint64) {
+	if t.additions++; t.additions >= t.sampleSize {
+		t.reset()
+	}
+	if !t.doorkeep(h) {
+		return
+	}
+	for i := 0; i < sketchDepth; i++ {
+		w, shift := t.slot(h, i)
+		if (t.table[w]>>shift)&0xf < 15 {
+			t.table[w] += 1 << shift
+		}
+	}
+}
+
+func (t *TinyLFU[K]) Admit(candidate, victim K) bool {
+	hc := maphash.Comparable(t.seed, candidate)
+	hv := maphash.Comparable(t.seed, victim)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.drain()
+	return t.estimate(hc) > t.estimate(hv)
+}
+
+// estimate returns the approximate access count for hash h.
+func (t *TinyLFU[K]) estimate(h uint64) int {
+	n := uint64(15)
+	for i := 0; i < sketchDepth; i++ {
+		w, shift := t.slot(h, i)
+		n = min(n, (t.table[w]>>shift)&0xf)
+	}
+	if t.seen(h) {
+		n++
+	}
+	return int(n)
+}
+
+// slot locates the counter of hash h in row i.
+func (t *TinyLFU[K]) slot(h uint64, i int) (word int, shift uint) {
+	// Derive the row index from two halves of the hash (Kirsch–Mitzenmacher).
+	idx := (h + uint64(i)*(h>>32|1)) & t.mask
+	pos := uint64(i)*(t.mask+1) + idx
+	return int(pos / 16), uint(pos%16) * 4
+}
+
+// doorkeep sets the doorkeeper bits of h and reports whether they were all
+// set already, i.e. whether the key has been seen since the last reset.
+func (t *TinyLFU[K]) doorkeep(h uint64) bool {
+	seen := true
+	for _, b := range [2]uint64{h & t.dmask, (h >> 32) & t.dmask} {
+		w, m := b/64, uint64(1)<<(b%64)
+		if t.door[w]&m == 0 {
+			seen = false
+			t.door[w] |= m
+		}
+	}
+	return seen
+}
+
+func (t *TinyLFU[K]) seen(h uint64) bool {
+	for _, b := range [2]uint64{h & t.dmask, (h >> 32) & t.dmask} {
+		if t.door[b/64]&(uint64(1)<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// reset halves every counter and clears the doorkeeper.
+func (t *TinyLFU[K]) reset() {
+	for i := range t.table {
+		t.table[i] = (t.table[i] >> 1) & 0x7777777777777777
+	}
+	clear(t.door)
+	t.additions = 0
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func replayHitRatio(t *testing.T, policy string, trace []string, capacity int) float64 {
+	t.Helper()
+	opt, err := PolicyByName[string, struct{}](policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewLRU(capacity, time.Hour, opt)
+	for _, k := range trace {
+		if _, ok := c.Get(k); !ok {
+			c.Set(k, struct{}{})
+		}
+	}
+	st := c.Stats()
+	return float64(st.Hits) / float64(st.Hits+st.Misses)
+}
+
+func TestTinyLFUResistsScans(t *testing.T) {
+	const capacity = 2000
+	trace := ScanTrace(20000, capacity, 300000)
+
+	lru := replayHitRatio(t, PolicyLRU, trace, capacity)
+	tinylfu := replayHitRatio(t, PolicyTinyLFU, trace, capacity)
+	t.Logf("hit ratio: lru %.3f, tinylfu %.3f", lru, tinylfu)
+	if tinylfu <= lru {
+		t.Errorf("tinylfu hit ratio %.3f, want above lru %.3f", tinylfu, lru)
+	}
+}
+
+type rejectAll[K comparable] struct{}
+
+func (rejectAll[K]) Record(K)        {}
+func (rejectAll[K]) Admit(K, K) bool { return false }
+
+func TestAddReportsRejection(t *testing.T) {
+	c := NewLRU(2, time.Hour, WithPolicy[string, int](func(int) Policy[string] { return rejectAll[string]{} }))
+	if !c.Add("a", 1) || !c.Add("b", 2) {
+		t.Fatal("Add into a cache with room failed")
+	}
+	if c.Add("c", 3) {
+		t.Error("Add reported a rejected entry as stored")
+	}
+	if _, ok := c.Get("c"); ok {
+		t.Error("rejected entry is cached")
+	}
+
+	c.Put("c", 3)
+	if _, ok := c.Get("c"); !ok {
+		t.Error("Put didn't bypass the admission policy")
+	}
+}
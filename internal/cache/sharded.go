@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// Sharded spreads keys over independent LRUs by key hash so that operations
+// on different keys rarely contend for the same lock. Capacity and the cost
+// budget are split between the shards and add up to the requested totals;
+// recency is tracked per shard.
+type Sharded[K comparable, V any] struct {
+	seed      maphash.Seed
+	shards    []*LRU[K, V]
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSharded creates a cache of the given total capacity over n shards. The
+// number of shards is capped at capacity so that each holds at least one entry.
+func NewSharded[K comparable, V any](capacity int, ttl time.Duration, n int, opts ...Option[K, V]) *Sharded[K, V] {
+	var o options[K, V]
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if capacity <= 0 {
+		capacity = 1
+	}
+	n = max(1, min(n, capacity))
+	if o.maxCost > 0 {
+		n = int(min(int64(n), o.maxCost))
+	}
+	s := &Sharded[K, V]{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*LRU[K, V], n),
+		stop:   make(chan struct{}),
+	}
+	for i := range s.shards {
+		so := o
+		so.maxCost = split(o.maxCost, n, i)
+		so.sweepInterval = 0 // one janitor for all shards, below
+		s.shards[i] = newLRU(split(capacity, n, i), ttl, so)
+	}
+	if o.sweepInterval > 0 {
+		go janitor(o.sweepInterval, s.stop, s.sweep)
+	}
+	return s
+}
+
+// split returns the share of total that falls to shard i of n.
+func split[T int | int64](total T, n, i int) T {
+	share := total / T(n)
+	if T(i) < total%T(n) {
+		share++
+	}
+	return share
+}
+
+func (s *Sharded[K, V]) index(key K) int {
+	return int(maphash.Comparable(s.seed, key) % uint64(len(s.shards)))
+}
+
+func (s *Sharded[K, V]) shard(key K) *LRU[K, V] { return s.shards[s.index(key)] }
+
+func (s *Sharded[K, V]) Get(key K) (V, bool) { return s.shard(key).Get(key) }
+func (s *Sharded[K, V]) Set(key K, value V)  { s.shard(key).Set(key, value) }
+func (s *Sharded[K, V]) Put(key K, value V)  { s.shard(key).Put(key, value) }
+func (s *Sharded[K, V]) GetEntry(key K) (V, time.Time, bool) {
+	return s.shard(key).GetEntry(key)
+}
+func (s *Sharded[K, V]) Add(key K, value V) bool { return s.shard(key).Add(key, value) }
+func (s *Sharded[K, V]) Delete(key K)            { s.shard(key).Delete(key) }
+
+// OnEvict registers fn with every shard.
+func (s *Sharded[K, V]) OnEvict(fn func(key K, value V, reason EvictReason)) {
+	for _, sh := range s.shards {
+		sh.OnEvict(fn)
+	}
+}
+
+// Entries concatenates the entries of all shards; recency order holds
+// within each shard only.
+func (s *Sharded[K, V]) Entries() []Entry[K, V] {
+	var res []Entry[K, V]
+	for _, sh := range s.shards {
+		res = append(res, sh.Entries()...)
+	}
+	return res
+}
+
+// Restore hands every entry to its shard, preserving their relative order.
+func (s *Sharded[K, V]) Restore(entries []Entry[K, V]) {
+	parts := make([][]Entry[K, V], len(s.shards))
+	for _, e := range entries {
+		i := s.index(e.Key)
+		parts[i] = append(parts[i], e)
+	}
+	for i, sh := range s.shards {
+		sh.Restore(parts[i])
+	}
+}
+
+// sweep sweeps the shards one by one, so only one shard is locked at a time.
+func (s *Sharded[K, V]) sweep() {
+	for _, sh := range s.shards {
+		sh.sweep()
+	}
+}
+
+// Close stops the janitor, if any.
+func (s *Sharded[K, V]) Close() {
+	s.closeOnce.Do(func() { close(s.stop) })
+}
+
+func (s *Sharded[K, V]) Len() int {
+	n := 0
+	for _, sh := range s.shards {
+		n += sh.Len()
+	}
+	return n
+}
+
+// Stats sums the counters of all shards.
+func (s *Sharded[K, V]) Stats() Stats {
+	var st Stats
+	for _, sh := range s.shards {
+		x := sh.Stats()
+		st.Hits += x.Hits
+		st.StaleHits += x.StaleHits
+		st.Misses += x.Misses
+		st.Evictions += x.Evictions
+		st.Expirations += x.Expirations
+		st.Deletions += x.Deletions
+		st.Replacements += x.Replacements
+		st.Rejections += x.Rejections
+		st.Size += x.Size
+		st.Capacity += x.Capacity
+		st.Cost += x.Cost
+		st.MaxCost += x.MaxCost
+	}
+	return st
+}
+
+// New returns an LRU, or a Sharded cache when shards > 1.
+func New[K comparable, V any](capacity int, ttl time.Duration, shards int, opts ...Option[K, V]) Cache[K, V] {
+	if shards > 1 {
+		return NewSharded(capacity, ttl, shards, opts...)
+	}
+	return NewLRU(capacity, ttl, opts...)
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot file layout, all integers big-endian:
+//
+//	magic   [4]byte "OCSN"
+//	version uint16
+//	created int64   unix nanoseconds
+//	length  uint64  of the payload
+//	payload         gob-encoded []Entry[K, V]
+//	crc     uint32  CRC-32 (IEEE) of the payload
+const (
+	snapshotMagic   = "OCSN"
+	snapshotVersion = 1
+)
+
+var (
+	ErrSnapshotCorrupt = errors.New("cache snapshot corrupt")
+	ErrSnapshotVersion = errors.New("cache snapshot version unsupported")
+	ErrSnapshotStale   = errors.New("cache snapshot too old")
+)
+
+type snapshotHeader struct {
+	Magic   [4]byte
+	Version uint16
+	Created int64
+	Length  uint64
+}
+
+// WriteSnapshot saves the entries of c to path. The file is written next to
+// path and renamed over it, so a crash never leaves a partial snapshot.
+func WriteSnapshot[K comparable, V any](path string, c Cache[K, V]) (n int, err error) {
+	entries := c.Entries()
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(entries); err != nil {
+		return 0, fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	h := snapshotHeader{Version: snapshotVersion, Created: time.Now().UnixNano(), Length: uint64(payload.Len())}
+	copy(h.Magic[:], snapshotMagic)
+	if err := binary.Write(w, binary.BigEndian, h); err != nil {
+		return 0, err
+	}
+	sum := crc32.ChecksumIEEE(payload.Bytes())
+	if _, err := payload.WriteTo(w); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, binary.BigEndian, sum); err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return len(entries), os.Rename(tmp.Name(), path)
+}
+
+// ReadSnapshot loads the entries saved by WriteSnapshot. It fails with
+// ErrSnapshotStale if the snapshot is older than maxAge (0 disables the
+// check), and with ErrSnapshotCorrupt or ErrSnapshotVersion if it can't be
+// trusted; a missing file yields an error matching os.ErrNotExist.
+func ReadSnapshot[K comparable, V any](path string, maxAge time.Duration) ([]Entry[K, V], time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var h snapshotHeader
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: header: %v", ErrSnapshotCorrupt, err)
+	}
+	if string(h.Magic[:]) != snapshotMagic {
+		return nil, time.Time{}, fmt.Errorf("%w: bad magic", ErrSnapshotCorrupt)
+	}
+	if h.Version != snapshotVersion {
+		return nil, time.Time{}, fmt.Errorf("%w: %d", ErrSnapshotVersion, h.Version)
+	}
+	created := time.Unix(0, h.Created)
+	if age := time.Since(created); maxAge > 0 && age > maxAge {
+		return nil, created, fmt.Errorf("%w: created %s ago", ErrSnapshotStale, age.Round(time.Second))
+	}
+
+	if st, err := f.Stat(); err == nil && h.Length > uint64(st.Size()) {
+		return nil, created, fmt.Errorf("%w: length %d exceeds file size", ErrSnapshotCorrupt, h.Length)
+	}
+	payload := make([]byte, h.Length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, created, fmt.Errorf("%w: payload: %v", ErrSnapshotCorrupt, err)
+	}
+	var sum uint32
+	if err := binary.Read(r, binary.BigEndian, &sum); err != nil {
+		return nil, created, fmt.Errorf("%w: checksum: %v", ErrSnapshotCorrupt, err)
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, created, fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+
+	var entries []Entry[K, V]
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&entries); err != nil {
+		return nil, created, fmt.Errorf("%w: decode: %v", ErrSnapshotCorrupt, err)
+	}
+	return entries, created, nil
+}
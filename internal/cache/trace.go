@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"math/rand"
+	"strconv"
+)
+
+// ScanTrace generates a key trace that mixes Zipf-distributed accesses over
+// keys with a scan over a run of never-repeated keys, as large as the cache,
+// every tenth of the trace. It is the workload admission policies are
+// compared on.
+func ScanTrace(keys, capacity, n int) []string {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.01, 1, uint64(keys-1))
+	t := make([]string, 0, n)
+	scan := 0
+	for len(t) < n {
+		if len(t)%(n/10+1) == n/20 {
+			for i := 0; i < capacity && len(t) < n; i++ {
+				t = append(t, "scan_"+strconv.Itoa(scan))
+				scan++
+			}
+			continue
+		}
+		t = append(t, "order_"+strconv.FormatUint(z.Uint64(), 10))
+	}
+	return t
+}
@@ -11,6 +11,10 @@ type Server struct {
 	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
 	WriteTimeout time.Duration `mapstructure:"write_timeout"`
 	IdleTimeout  time.Duration `mapstructure:"idle_timeout"`
+	// ShutdownDelay is how long readiness reports draining before the
+	// server stops accepting connections.
+	ShutdownDelay time.Duration `mapstructure:"shutdown_delay"`
+	CheckTimeout  time.Duration `mapstructure:"check_timeout"`
 }
 
 type DB struct {
@@ -27,11 +31,86 @@ type Kafka struct {
 	MinBytes       int           `mapstructure:"min_bytes"`
 	MaxBytes       int           `mapstructure:"max_bytes"`
 	CommitInterval time.Duration `mapstructure:"commit_interval"`
+	DLQ            DLQ           `mapstructure:"dlq"`
+	Retry          Retry         `mapstructure:"retry"`
+	Workers        int           `mapstructure:"workers"`
+	QueueSize      int           `mapstructure:"queue_size"`
+	Ordering       string        `mapstructure:"ordering"`
+	Batch          Batch         `mapstructure:"batch"`
+	Outbox         Outbox        `mapstructure:"outbox"`
+	Dedupe         Dedupe        `mapstructure:"dedupe"`
+}
+
+// Dedupe bounds the record of processed Kafka messages.
+type Dedupe struct {
+	Retention       time.Duration `mapstructure:"retention"`
+	CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
+}
+
+// Outbox is the relay of the order_events table to the events topic; an
+// empty topic disables it.
+type Outbox struct {
+	Topic           string        `mapstructure:"topic"`
+	WriteTimeout    time.Duration `mapstructure:"write_timeout"`
+	BatchSize       int           `mapstructure:"batch_size"`
+	PollInterval    time.Duration `mapstructure:"poll_interval"`
+	Retention       time.Duration `mapstructure:"retention"`
+	CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
+}
+
+type Batch struct {
+	Size int           `mapstructure:"size"`
+	Wait time.Duration `mapstructure:"wait"`
+}
+
+type Retry struct {
+	MaxAttempts    int           `mapstructure:"max_attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+	Multiplier     float64       `mapstructure:"multiplier"`
+	Jitter         float64       `mapstructure:"jitter"`
+	ParkingTopic   string        `mapstructure:"parking_topic"`
+}
+
+type DLQ struct {
+	Topic        string        `mapstructure:"topic"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+}
+
+// Snapshot is the cache dump written on shutdown and read on startup. An
+// empty path disables it; snapshots older than MaxAge are ignored.
+type Snapshot struct {
+	Path   string        `mapstructure:"path"`
+	MaxAge time.Duration `mapstructure:"max_age"`
+}
+
+// Warmup controls the background cache fill from the database. The service
+// reports ready once ReadyFraction of the cache capacity is loaded.
+type Warmup struct {
+	ChunkSize     int     `mapstructure:"chunk_size"`
+	ReadyFraction float64 `mapstructure:"ready_fraction"`
 }
 
 type Cache struct {
-	Capacity int           `mapstructure:"capacity"`
-	TTL      time.Duration `mapstructure:"ttl"`
+	Capacity    int           `mapstructure:"capacity"`
+	TTL         time.Duration `mapstructure:"ttl"`
+	NegativeTTL time.Duration `mapstructure:"negative_ttl"`
+	Shards      int           `mapstructure:"shards"`
+	MaxBytes    int64         `mapstructure:"max_bytes"`
+	// SweepInterval is how often expired entries are removed; 0 leaves them
+	// until they are looked up or evicted.
+	SweepInterval time.Duration `mapstructure:"sweep_interval"`
+	// Policy is the admission policy: lru (admit everything) or tinylfu.
+	Policy string `mapstructure:"policy"`
+	// StaleGrace is how long after expiry an order may still be served while
+	// it is reloaded; RefreshAhead reloads orders read this close to expiry.
+	StaleGrace   time.Duration `mapstructure:"stale_grace"`
+	RefreshAhead time.Duration `mapstructure:"refresh_ahead"`
+	Snapshot     Snapshot      `mapstructure:"snapshot"`
+	Warmup       Warmup        `mapstructure:"warmup"`
+	// ListenChanges evicts orders changed by other replicas, as announced
+	// over Postgres LISTEN/NOTIFY.
+	ListenChanges bool `mapstructure:"listen_changes"`
 }
 
 type UI struct {
@@ -0,0 +1,143 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Kind classifies an error so that callers in different layers can decide
+// what to do with it without matching on messages or package sentinels.
+type Kind uint8
+
+const (
+	KindUnknown Kind = iota
+	KindValidation
+	KindNotFound
+	KindConflict
+	KindTransient
+	KindPermanent
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindValidation:
+		return "validation"
+	case KindNotFound:
+		return "not_found"
+	case KindConflict:
+		return "conflict"
+	case KindTransient:
+		return "transient"
+	case KindPermanent:
+		return "permanent"
+	default:
+		return "unknown"
+	}
+}
+
+// HTTPStatus maps the kind to the status code returned by the HTTP API.
+func (k Kind) HTTPStatus() int {
+	switch k {
+	case KindValidation:
+		return http.StatusUnprocessableEntity
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindTransient:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Action is what the Kafka consumer does with a message whose processing
+// failed with an error of a given kind.
+type Action uint8
+
+const (
+	// ActionRetry re-processes the message according to the retry policy.
+	ActionRetry Action = iota
+	// ActionDeadLetter publishes the message to the DLQ and commits it.
+	ActionDeadLetter
+	// ActionSkip commits the message without further processing.
+	ActionSkip
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionDeadLetter:
+		return "dead_letter"
+	case ActionSkip:
+		return "skip"
+	default:
+		return "retry"
+	}
+}
+
+// ConsumerAction maps the kind to the consumer's reaction. Unknown errors are
+// retried: they are mostly infrastructure failures that were not classified.
+func (k Kind) ConsumerAction() Action {
+	switch k {
+	case KindValidation, KindPermanent:
+		return ActionDeadLetter
+	case KindNotFound:
+		return ActionSkip
+	default:
+		return ActionRetry
+	}
+}
+
+// Error attaches a Kind and the failed operation to an underlying error.
+type Error struct {
+	kind Kind
+	Op   string
+	Err  error
+}
+
+// E wraps err with the given kind. It returns nil for a nil err.
+func E(kind Kind, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{kind: kind, Op: op, Err: err}
+}
+
+// New returns a sentinel error of the given kind.
+func New(kind Kind, msg string) error {
+	return &Error{kind: kind, Err: errors.New(msg)}
+}
+
+func (e *Error) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func (e *Error) Kind() Kind { return e.kind }
+
+// KindOf returns the kind of the outermost classified error in err's chain.
+// Context cancellation and deadlines that were not classified explicitly are
+// reported as transient.
+func KindOf(err error) Kind {
+	if err == nil {
+		return KindUnknown
+	}
+	var k interface{ Kind() Kind }
+	if errors.As(err, &k) {
+		return k.Kind()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return KindTransient
+	}
+	return KindUnknown
+}
+
+// Is reports whether err is classified as kind.
+func Is(err error, kind Kind) bool {
+	return KindOf(err) == kind
+}
@@ -0,0 +1,134 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CheckFunc reports whether a dependency is usable.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+	// informational checks are reported but don't fail readiness.
+	informational bool
+}
+
+// Checker serves liveness and readiness probes.
+type Checker struct {
+	timeout  time.Duration
+	checks   []check
+	draining atomic.Bool
+}
+
+func New(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a readiness check. It must be called before serving.
+func (c *Checker) Add(name string, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// AddInformational registers a check that is reported by the readiness probe
+// but doesn't fail it, for dependencies that serving HTTP doesn't need.
+func (c *Checker) AddInformational(name string, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, fn: fn, informational: true})
+}
+
+// SetDraining makes readiness fail regardless of checks, so that load
+// balancers stop routing traffic before the server shuts down.
+func (c *Checker) SetDraining(v bool) {
+	c.draining.Store(v)
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+var errDraining = errors.New("shutting down")
+
+// Check runs all readiness checks concurrently, each bounded by the timeout.
+func (c *Checker) Check(ctx context.Context) Report {
+	rep := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(c.checks))}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, ch := range c.checks {
+		wg.Add(1)
+		go func(ch check) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := ch.fn(cctx)
+			res := CheckResult{Status: StatusOK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				res.Status = StatusFail
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			rep.Checks[ch.name] = res
+			if err != nil && !ch.informational {
+				rep.Status = StatusFail
+			}
+			mu.Unlock()
+		}(ch)
+	}
+	wg.Wait()
+
+	if c.draining.Load() {
+		rep.Status = StatusDraining
+		rep.Checks["shutdown"] = CheckResult{Status: StatusFail, Error: errDraining.Error()}
+	}
+	return rep
+}
+
+// Liveness answers 200 while the process is able to serve HTTP at all.
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: StatusOK})
+}
+
+// Readiness answers 200 only if every check but the informational ones
+// passes and the server is not draining, 503 otherwise.
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	rep := c.Check(r.Context())
+	status := http.StatusOK
+	if rep.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, rep)
+}
+
+func writeReport(w http.ResponseWriter, status int, rep Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(rep)
+}
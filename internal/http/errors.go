@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"wb-snilez-l0/internal/errs"
+	"wb-snilez-l0/internal/log"
+	"wb-snilez-l0/internal/model"
+)
+
+// StatusClientClosedRequest is the nginx convention for requests whose client
+// went away before the response was ready.
+const StatusClientClosedRequest = 499
+
+// Error codes of the JSON error envelope.
+const (
+	CodeBadRequest          = "bad_request"
+	CodeValidation          = "validation_failed"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeConflict            = "conflict"
+	CodeClientClosedRequest = "client_closed_request"
+	CodeUnavailable         = "unavailable"
+	CodeInternal            = "internal"
+)
+
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+type errorEnvelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+// requestError is a client mistake detected by the HTTP layer itself, such as
+// a malformed query parameter or body.
+type requestError struct {
+	msg string
+}
+
+func (e *requestError) Error() string { return e.msg }
+
+func badRequest(format string, args ...any) error {
+	return &requestError{msg: fmt.Sprintf(format, args...)}
+}
+
+// HandlerFunc is an HTTP handler that reports failures by returning an error
+// instead of writing the response itself.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// Wrap adapts fn to http.HandlerFunc, turning a returned error into the JSON
+// error envelope with a status derived from the error kind.
+func (h *Handler) Wrap(fn HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(w, r); err != nil {
+			h.writeError(w, r, err)
+		}
+	}
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, body := errorResponse(err)
+
+	l := log.FromContext(r.Context())
+	switch {
+	case status >= http.StatusInternalServerError:
+		l.Error("request failed",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", status),
+			zap.Error(err),
+		)
+	case status == StatusClientClosedRequest:
+		l.Debug("client closed request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+		)
+	}
+
+	writeEnvelope(w, r, status, body)
+}
+
+func writeEnvelope(w http.ResponseWriter, r *http.Request, status int, body ErrorBody) {
+	body.RequestID = requestID(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorEnvelope{Error: body})
+}
+
+// errorResponse maps err to a status code and envelope. Messages of server
+// errors are generic so that internals don't leak to clients.
+func errorResponse(err error) (int, ErrorBody) {
+	var rerr *requestError
+	if errors.As(err, &rerr) {
+		return http.StatusBadRequest, ErrorBody{Code: CodeBadRequest, Message: rerr.msg}
+	}
+	if errors.Is(err, context.Canceled) {
+		return StatusClientClosedRequest, ErrorBody{Code: CodeClientClosedRequest, Message: "client closed request"}
+	}
+
+	kind := errs.KindOf(err)
+	status := kind.HTTPStatus()
+	body := ErrorBody{Message: publicMessage(err, status)}
+	switch kind {
+	case errs.KindValidation:
+		body.Code = CodeValidation
+		body.Message = "validation failed"
+		var verrs model.ValidationErrors
+		if errors.As(err, &verrs) {
+			body.Details = []model.ValidationError(verrs)
+		}
+	case errs.KindNotFound:
+		body.Code = CodeNotFound
+	case errs.KindConflict:
+		body.Code = CodeConflict
+	case errs.KindTransient:
+		body.Code = CodeUnavailable
+	default:
+		body.Code = CodeInternal
+	}
+	return status, body
+}
+
+// publicMessage returns the text of a sentinel error, which is written for
+// clients, or the generic status text otherwise.
+func publicMessage(err error, status int) string {
+	var e *errs.Error
+	if status < http.StatusInternalServerError && errors.As(err, &e) && e.Op == "" {
+		return e.Error()
+	}
+	return http.StatusText(status)
+}
+
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		return id
+	}
+	return w.Header().Get(HeaderRequestID)
+}
+
+const HeaderRequestID = "X-Request-ID"
@@ -2,10 +2,16 @@ package http
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
+	"wb-snilez-l0/internal/model"
+	"wb-snilez-l0/internal/repo"
 	"wb-snilez-l0/internal/service"
 )
 
@@ -18,22 +24,231 @@ func NewHandler(s *service.Service, l *zap.Logger) *Handler {
 	return &Handler{svc: s, log: l}
 }
 
-func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) error {
 	uid := strings.TrimPrefix(r.URL.Path, "/order/")
 	if uid == "" {
-		http.Error(w, "order id required", http.StatusBadRequest)
-		return
+		return badRequest("order id required")
 	}
 
 	ctx := r.Context()
 	o, err := h.svc.Get(ctx, uid)
 	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
-		return
+		return err
 	}
 
+	writeJSON(w, http.StatusOK, o)
+	return nil
+}
+
+func (h *Handler) GetOrderHistory(w http.ResponseWriter, r *http.Request) error {
+	history, err := h.svc.History(r.Context(), r.PathValue("uid"))
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, history)
+	return nil
+}
+
+func (h *Handler) GetOrderRevision(w http.ResponseWriter, r *http.Request) error {
+	version, err := strconv.ParseInt(r.PathValue("version"), 10, 64)
+	if err != nil || version <= 0 {
+		return badRequest("version: must be a positive integer")
+	}
+	rev, err := h.svc.Revision(r.Context(), r.PathValue("uid"), version)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, rev)
+	return nil
+}
+
+func (h *Handler) GetOrderByTrack(w http.ResponseWriter, r *http.Request) error {
+	o, err := h.svc.GetByTrack(r.Context(), r.PathValue("track"))
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, o)
+	return nil
+}
+
+func (h *Handler) GetOrderByTransaction(w http.ResponseWriter, r *http.Request) error {
+	o, err := h.svc.GetByTransaction(r.Context(), r.PathValue("tx"))
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, o)
+	return nil
+}
+
+func (h *Handler) ListCustomerOrders(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return badRequest("limit: must be a positive integer")
+		}
+	}
+
+	page, err := h.svc.ListByCustomer(r.Context(), r.PathValue("customer_id"), limit, q.Get("cursor"))
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, page)
+	return nil
+}
+
+func (h *Handler) ListOrders(w http.ResponseWriter, r *http.Request) error {
+	f, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	page, err := h.svc.Search(r.Context(), f)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, page)
+	return nil
+}
+
+// maxOrderBody bounds the size of order payloads accepted by the write API.
+const maxOrderBody = 1 << 20
+
+func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) error {
+	o, err := decodeOrder(w, r)
+	if err != nil {
+		return err
+	}
+	if verrs := o.Validate(); len(verrs) > 0 {
+		return model.ValidationErrors(verrs)
+	}
+	if err := h.svc.Create(r.Context(), o, apiSource(r)); err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/order/"+url.PathEscape(o.OrderUID))
+	writeJSON(w, http.StatusCreated, o)
+	return nil
+}
+
+func (h *Handler) UpdateOrder(w http.ResponseWriter, r *http.Request) error {
+	uid := r.PathValue("uid")
+	o, err := decodeOrder(w, r)
+	if err != nil {
+		return err
+	}
+	if o.OrderUID == "" {
+		o.OrderUID = uid
+	}
+	if o.OrderUID != uid {
+		return model.ValidationErrors{{Field: "order_uid", Message: "does not match the URL"}}
+	}
+	if verrs := o.Validate(); len(verrs) > 0 {
+		return model.ValidationErrors(verrs)
+	}
+	res, err := h.svc.Put(r.Context(), o, apiSource(r))
+	if err != nil {
+		return err
+	}
+	if res == repo.OutcomeStale {
+		return service.ErrStale
+	}
+	writeJSON(w, http.StatusOK, o)
+	return nil
+}
+
+// apiSource identifies an API write by its request ID and caller; it is
+// versioned by the time it is stored.
+func apiSource(r *http.Request) repo.Source {
+	return repo.Source{Kind: repo.SourceAPI, Ref: RequestIDFromContext(r.Context()), Caller: caller(r)}
+}
+
+// caller names the client of r: the first X-Forwarded-For address if a proxy
+// set one, the peer address otherwise. The service has no authentication, so
+// this is the best identity there is.
+func caller(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		if first = strings.TrimSpace(first); first != "" {
+			return first
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func (h *Handler) DeleteOrder(w http.ResponseWriter, r *http.Request) error {
+	if err := h.svc.Delete(r.Context(), r.PathValue("uid"), apiSource(r)); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func decodeOrder(w http.ResponseWriter, r *http.Request) (*model.Order, error) {
+	var o model.Order
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOrderBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o); err != nil {
+		return nil, badRequest("invalid order json: %v", err)
+	}
+	return &o, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
-	_ = enc.Encode(o)
+	_ = enc.Encode(v)
+}
+
+func parseOrderFilter(q url.Values) (repo.OrderFilter, error) {
+	f := repo.OrderFilter{
+		CustomerID:      q.Get("customer_id"),
+		TrackNumber:     q.Get("track_number"),
+		DeliveryService: q.Get("delivery_service"),
+		PaymentProvider: q.Get("payment_provider"),
+		PaymentCurrency: q.Get("payment_currency"),
+		ItemBrand:       q.Get("brand"),
+		Cursor:          q.Get("cursor"),
+	}
+
+	var err error
+	if v := q.Get("created_from"); v != "" {
+		if f.CreatedFrom, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, badRequest("created_from: %v", err)
+		}
+	}
+	if v := q.Get("created_to"); v != "" {
+		if f.CreatedTo, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, badRequest("created_to: %v", err)
+		}
+	}
+	if v := q.Get("nm_id"); v != "" {
+		if f.ItemNMID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return f, badRequest("nm_id: %v", err)
+		}
+	}
+	if v := q.Get("status"); v != "" {
+		if f.ItemStatus, err = strconv.Atoi(v); err != nil {
+			return f, badRequest("status: %v", err)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 {
+			return f, badRequest("limit: must be a positive integer")
+		}
+	}
+	switch q.Get("sort") {
+	case "", "-date_created":
+	case "date_created":
+		f.Asc = true
+	default:
+		return f, badRequest("sort: must be date_created or -date_created")
+	}
+	return f, nil
 }
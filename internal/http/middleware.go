@@ -0,0 +1,231 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"wb-snilez-l0/internal/log"
+	"wb-snilez-l0/internal/metrics"
+)
+
+// Middleware wraps a handler with cross-cutting behaviour.
+type Middleware func(http.Handler) http.Handler
+
+// Chain applies mws to h so that the first middleware is the outermost.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request ID set by RequestContext.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestContext takes the request ID from X-Request-ID or generates one,
+// echoes it in the response and puts it, together with a logger tagged with
+// it, into the request context. It must be the outermost middleware: the
+// ones inside share the request it creates.
+func RequestContext(base *zap.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(HeaderRequestID)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(HeaderRequestID, id)
+
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			ctx = log.WithContext(ctx, base.With(zap.String("request_id", id)))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// AccessLog writes one structured log entry per request.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		log.FromContext(r.Context()).Info("http request",
+			zap.String("method", r.Method),
+			zap.String("route", route(r)),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", rec.code()),
+			zap.Int("bytes", rec.bytes),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("remote_addr", r.RemoteAddr),
+		)
+	})
+}
+
+// Recover turns a handler panic into a logged 500 response.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			log.FromContext(r.Context()).Error("handler panic",
+				zap.Any("panic", p),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.ByteString("stack", debug.Stack()),
+			)
+			if rec.status == 0 {
+				writeEnvelope(w, r, http.StatusInternalServerError, ErrorBody{
+					Code:    CodeInternal,
+					Message: http.StatusText(http.StatusInternalServerError),
+				})
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// ErrorEnvelope turns the plain-text error responses that handlers outside
+// the API write with http.Error, such as the ServeMux 404 and 405 and the
+// file server errors, into the JSON error envelope. Headers set before the
+// error, like Allow, are kept.
+func ErrorEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&envelopeWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+type envelopeWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	wroteHeader bool
+	replaced    bool
+}
+
+func (e *envelopeWriter) WriteHeader(code int) {
+	if e.wroteHeader {
+		return
+	}
+	e.wroteHeader = true
+	if code < http.StatusBadRequest || !strings.HasPrefix(e.Header().Get("Content-Type"), "text/plain") {
+		e.ResponseWriter.WriteHeader(code)
+		return
+	}
+	e.replaced = true
+	writeEnvelope(e.ResponseWriter, e.r, code, ErrorBody{Code: statusCode(code), Message: http.StatusText(code)})
+}
+
+func (e *envelopeWriter) Write(b []byte) (int, error) {
+	if !e.wroteHeader {
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.replaced {
+		return len(b), nil
+	}
+	return e.ResponseWriter.Write(b)
+}
+
+func (e *envelopeWriter) Unwrap() http.ResponseWriter { return e.ResponseWriter }
+
+// statusCode is the envelope code of an error status set outside the API.
+func statusCode(status int) string {
+	switch {
+	case status == http.StatusNotFound:
+		return CodeNotFound
+	case status == http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case status >= http.StatusInternalServerError:
+		return CodeInternal
+	default:
+		return CodeBadRequest
+	}
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+func (r *statusRecorder) code() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Instrument records request counts and latencies per route.
+func Instrument(m *metrics.Metrics) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			m.ObserveHTTP(r.Method, route(r), rec.code(), time.Since(start))
+		})
+	}
+}
+
+// route is the ServeMux pattern that matched, so path parameters don't blow
+// up log and metric cardinality. ServeMux sets it on the request it was
+// given, which is only visible here if no middleware in between replaced it.
+func route(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
+	}
+	return r.Pattern
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /orders/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /orders/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.Handle("GET /", http.FileServer(http.Dir(dir)))
+	// Without the UI the ServeMux answers unknown paths itself.
+	api := http.NewServeMux()
+	api.HandleFunc("GET /orders", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name, method, path string
+		h                  http.Handler
+		status             int
+		code               string
+		allow              string
+	}{
+		{name: "unknown path", method: http.MethodGet, path: "/nope", h: api, status: http.StatusNotFound, code: CodeNotFound},
+		{name: "unknown file", method: http.MethodGet, path: "/nope", h: mux, status: http.StatusNotFound, code: CodeNotFound},
+		{name: "method not allowed", method: http.MethodPatch, path: "/orders/x", h: mux, status: http.StatusMethodNotAllowed, code: CodeMethodNotAllowed, allow: "DELETE, GET, HEAD, PUT"},
+		{name: "matched route", method: http.MethodPut, path: "/orders/x", h: mux, status: http.StatusNoContent},
+		{name: "static file", method: http.MethodGet, path: "/", h: mux, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ErrorEnvelope(tt.h).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow %q, want %q", got, tt.allow)
+			}
+			if tt.code == "" {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type %q, want application/json", ct)
+			}
+			var env errorEnvelope
+			if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+				t.Fatalf("body %q: %v", rec.Body, err)
+			}
+			if env.Error.Code != tt.code || env.Error.Message != http.StatusText(tt.status) {
+				t.Errorf("got %+v, want code %q", env.Error, tt.code)
+			}
+		})
+	}
+}
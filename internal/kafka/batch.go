@@ -0,0 +1,127 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"wb-snilez-l0/internal/errs"
+	"wb-snilez-l0/internal/repo"
+
+	kgo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// collect blocks for the first message on q and then keeps reading until the
+// batch is full or batchWait elapses. It returns nil once q is closed and empty.
+func (c *Consumer) collect(q <-chan kgo.Message) []kgo.Message {
+	m, ok := <-q
+	if !ok {
+		return nil
+	}
+	batch := make([]kgo.Message, 1, c.batchSize)
+	batch[0] = m
+
+	timer := time.NewTimer(c.batchWait)
+	defer timer.Stop()
+	for len(batch) < c.batchSize {
+		select {
+		case m, ok := <-q:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, m)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+// processBatch decodes msgs and stores the valid orders through
+// Service.PutBatch, retrying only the orders that failed with a retryable
+// error. It reports per message whether it may be committed.
+func (c *Consumer) processBatch(ctx context.Context, msgs []kgo.Message) []bool {
+	done := make([]bool, len(msgs))
+
+	var (
+		idx    []int
+		writes []repo.Write
+	)
+	for i, m := range msgs {
+		o, ok := c.decode(ctx, m)
+		if o == nil {
+			done[i] = ok
+			continue
+		}
+		idx = append(idx, i)
+		writes = append(writes, repo.Write{Order: o, Source: messageSource(m)})
+	}
+
+	pending := make([]int, len(writes))
+	for j := range pending {
+		pending[j] = j
+	}
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		batch := make([]repo.Write, len(pending))
+		for j, k := range pending {
+			batch[j] = writes[k]
+		}
+		results := c.svc.PutBatch(ctx, batch)
+
+		var retry []int
+		var lastErr error
+		for j, r := range results {
+			k := pending[j]
+			m, o := msgs[idx[k]], writes[k].Order
+			err := r.Err
+			if err == nil {
+				c.stored(m, o, r.Outcome)
+				done[idx[k]] = true
+				continue
+			}
+			retryable := errs.KindOf(err).ConsumerAction() == errs.ActionRetry
+			if retryable && ctx.Err() == nil && attempt < c.retry.MaxAttempts {
+				c.m.MessageRetried(errs.KindOf(err).String())
+				retry = append(retry, k)
+				lastErr = err
+				continue
+			}
+			if retryable && ctx.Err() == nil {
+				c.log.Error("store order failed, retries exhausted",
+					zap.String("order_uid", o.OrderUID),
+					zap.Int("partition", m.Partition),
+					zap.Int64("offset", m.Offset),
+					zap.Int("attempts", attempt),
+					zap.Error(err),
+				)
+				err = errors.Join(errRetriesExhausted, err)
+			}
+			done[idx[k]] = c.storeFailed(ctx, m, o, attempt, err)
+		}
+
+		c.log.Info("order batch processed",
+			zap.Int("orders", len(pending)),
+			zap.Int("to_retry", len(retry)),
+			zap.Int("attempt", attempt),
+		)
+		if len(retry) == 0 {
+			break
+		}
+
+		delay := c.retry.Backoff(attempt)
+		c.log.Warn("store order batch failed, retrying",
+			zap.Int("orders", len(retry)),
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", c.retry.MaxAttempts),
+			zap.Duration("backoff", delay),
+			zap.Error(lastErr),
+		)
+		if sleepCtx(ctx, delay) != nil {
+			break
+		}
+		pending = retry
+	}
+	return done
+}
@@ -4,9 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"wb-snilez-l0/internal/errs"
+	"wb-snilez-l0/internal/log"
+	"wb-snilez-l0/internal/metrics"
 	"wb-snilez-l0/internal/model"
+	"wb-snilez-l0/internal/repo"
 	"wb-snilez-l0/internal/service"
 
 	kgo "github.com/segmentio/kafka-go"
@@ -14,9 +20,26 @@ import (
 )
 
 type Consumer struct {
-	reader *kgo.Reader
-	svc    *service.Service
-	log    *zap.Logger
+	brokers []string
+	groupID string
+	reader  *kgo.Reader
+	dlq     *kgo.Writer
+	parking *kgo.Writer
+	retry   RetryPolicy
+	svc     *service.Service
+	log     *zap.Logger
+	m       *metrics.Metrics
+
+	workers   int
+	queueSize int
+	ordering  string
+	batchSize int
+	batchWait time.Duration
+	dedupe    DedupeConfig
+	offsets   *offsetTracker
+	pool      atomic.Pointer[pool]
+	inFlight  atomic.Int64
+	processed atomic.Uint64
 }
 
 type Config struct {
@@ -26,9 +49,40 @@ type Config struct {
 	MinBytes       int
 	MaxBytes       int
 	CommitInterval time.Duration
+
+	DLQTopic        string
+	DLQWriteTimeout time.Duration
+
+	Retry RetryPolicy
+	// ParkingTopic receives messages whose store retries ran out.
+	// Defaults to DLQTopic when empty.
+	ParkingTopic string
+
+	// Workers is the number of messages processed concurrently.
+	Workers int
+	// QueueSize is the per-worker buffer of fetched messages.
+	QueueSize int
+	// Ordering is OrderByPartition (default) or OrderByKey.
+	Ordering string
+
+	// BatchSize enables batch ingest when greater than 1: each worker collects
+	// up to BatchSize messages, waiting at most BatchWait, and stores them in
+	// one transaction.
+	BatchSize int
+	BatchWait time.Duration
+
+	Dedupe DedupeConfig
+}
+
+// DedupeConfig bounds the record of processed messages kept to recognize
+// redeliveries: entries older than Retention are deleted every
+// CleanupInterval. A zero Retention keeps them forever.
+type DedupeConfig struct {
+	Retention       time.Duration
+	CleanupInterval time.Duration
 }
 
-func New(cfg Config, svc *service.Service, log *zap.Logger) *Consumer {
+func New(cfg Config, svc *service.Service, log *zap.Logger, m *metrics.Metrics) *Consumer {
 	r := kgo.NewReader(kgo.ReaderConfig{
 		Brokers:        cfg.Brokers,
 		Topic:          cfg.Topic,
@@ -37,11 +91,67 @@ func New(cfg Config, svc *service.Service, log *zap.Logger) *Consumer {
 		MaxBytes:       cfg.MaxBytes,
 		CommitInterval: cfg.CommitInterval,
 	})
-	return &Consumer{reader: r, svc: svc, log: log}
+	c := &Consumer{
+		brokers: cfg.Brokers,
+		groupID: cfg.GroupID,
+		reader:  r,
+		dlq:     newWriter(cfg.Brokers, cfg.DLQTopic, cfg.DLQWriteTimeout),
+		retry:   cfg.Retry.withDefaults(),
+		svc:     svc,
+		log:     log,
+		m:       m,
+
+		workers:   cfg.Workers,
+		queueSize: cfg.QueueSize,
+		ordering:  cfg.Ordering,
+		batchSize: cfg.BatchSize,
+		batchWait: cfg.BatchWait,
+		dedupe:    cfg.Dedupe,
+		offsets:   newOffsetTracker(),
+	}
+	if c.workers <= 0 {
+		c.workers = 1
+	}
+	if c.queueSize <= 0 {
+		c.queueSize = 16
+	}
+	if c.batchWait <= 0 {
+		c.batchWait = 100 * time.Millisecond
+	}
+	if c.dedupe.CleanupInterval <= 0 {
+		c.dedupe.CleanupInterval = time.Hour
+	}
+	if c.ordering != OrderByKey {
+		c.ordering = OrderByPartition
+	}
+	c.parking = c.dlq
+	if cfg.ParkingTopic != "" && cfg.ParkingTopic != cfg.DLQTopic {
+		c.parking = newWriter(cfg.Brokers, cfg.ParkingTopic, cfg.DLQWriteTimeout)
+	}
+	c.registerMetrics()
+	return c
 }
 
 func (c *Consumer) Run(ctx context.Context) error {
 	defer c.reader.Close()
+	if c.dlq != nil {
+		defer c.dlq.Close()
+	}
+	if c.parking != nil && c.parking != c.dlq {
+		defer c.parking.Close()
+	}
+
+	p := c.startPool(ctx)
+	defer p.stop()
+	if c.dedupe.Retention > 0 {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			c.forgetProcessed(ctx)
+		}()
+		defer func() { <-done }()
+	}
+
 	for {
 		m, err := c.reader.FetchMessage(ctx)
 		if err != nil {
@@ -52,52 +162,155 @@ func (c *Consumer) Run(ctx context.Context) error {
 			continue
 		}
 
-		var o model.Order
-		if err := json.Unmarshal(m.Value, &o); err != nil {
-			c.log.Warn("invalid message json, skip", zap.Error(err))
-			_ = c.reader.CommitMessages(ctx, m)
-			continue
-		}
-
-		if validationErrors := o.Validate(); len(validationErrors) > 0 {
-			c.log.Warn("invalid order data, skip",
-				zap.String("order_uid", o.OrderUID),
-				zap.Any("validation_errors", validationErrors),
-			)
-			_ = c.reader.CommitMessages(ctx, m)
-			continue
+		c.m.MessageConsumed(m.Partition, m.Offset, m.HighWaterMark)
+		c.offsets.track(m)
+		if !p.dispatch(ctx, m) {
+			return nil
 		}
+	}
+}
 
-		if o.DateCreated.IsZero() {
-			c.log.Warn("order has zero date, using current time",
-				zap.String("order_uid", o.OrderUID),
-			)
-			o.DateCreated = time.Now()
-		}
+// process handles a single message. It returns false when processing was
+// interrupted by ctx and the message must not be committed.
+func (c *Consumer) process(ctx context.Context, m kgo.Message) bool {
+	ctx = log.WithContext(ctx, c.log.With(
+		zap.Int("partition", m.Partition),
+		zap.Int64("offset", m.Offset),
+	))
+	o, done := c.decode(ctx, m)
+	if o == nil {
+		return done
+	}
 
-		if err := c.svc.Put(ctx, &o); err != nil {
-			if errors.Is(err, errors.New("validation error")) {
-				c.log.Warn("service validation failed, skip",
-					zap.String("order_uid", o.OrderUID),
-					zap.Error(err),
-				)
-				_ = c.reader.CommitMessages(ctx, m)
-				continue
-			}
+	res, attempts, err := c.storeWithRetry(ctx, m, o)
+	if err != nil {
+		return c.storeFailed(ctx, m, o, attempts, err)
+	}
+	c.stored(m, o, res)
+	return true
+}
 
-			c.log.Error("store order failed", zap.Error(err))
-			time.Sleep(time.Second)
+// forgetProcessed deletes old records of processed messages until ctx is
+// done.
+func (c *Consumer) forgetProcessed(ctx context.Context) {
+	t := time.NewTicker(c.dedupe.CleanupInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		n, err := c.svc.ForgetProcessed(ctx, time.Now().Add(-c.dedupe.Retention))
+		if err != nil {
+			c.log.Warn("clean up processed messages", zap.Error(err))
 			continue
 		}
+		if n > 0 {
+			c.log.Info("processed messages cleaned up", zap.Int64("deleted", n))
+		}
+	}
+}
 
+// messageSource identifies m as the source of the order it carries; the
+// message timestamp orders the writes.
+func messageSource(m kgo.Message) repo.Source {
+	src := repo.Source{
+		Kind:    repo.SourceKafka,
+		Ref:     m.Topic + "/" + strconv.Itoa(m.Partition) + "/" + strconv.FormatInt(m.Offset, 10),
+		Message: &repo.Message{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset},
+	}
+	if !m.Time.IsZero() {
+		src.Version = m.Time.UnixNano()
+	}
+	return src
+}
+
+// stored logs and counts the outcome of storing the order of m. Stale,
+// duplicate and unchanged writes are expected with redeliveries and
+// reordering, so they are not errors.
+func (c *Consumer) stored(m kgo.Message, o *model.Order, res repo.Outcome) {
+	c.m.MessageStored(res.String())
+	switch res {
+	case repo.OutcomeApplied:
 		c.log.Info("order processed successfully",
 			zap.String("order_uid", o.OrderUID),
 		)
+	case repo.OutcomeStale:
+		c.log.Warn("stale order update skipped",
+			zap.String("order_uid", o.OrderUID),
+			zap.Int("partition", m.Partition),
+			zap.Int64("offset", m.Offset),
+			zap.Time("message_time", m.Time),
+		)
+	case repo.OutcomeDuplicate:
+		c.log.Info("duplicate order message skipped",
+			zap.String("order_uid", o.OrderUID),
+			zap.Int("partition", m.Partition),
+			zap.Int64("offset", m.Offset),
+		)
+	case repo.OutcomeUnchanged:
+		c.log.Info("unchanged order skipped",
+			zap.String("order_uid", o.OrderUID),
+			zap.Int("partition", m.Partition),
+			zap.Int64("offset", m.Offset),
+		)
+	}
+}
 
-		if err := c.reader.CommitMessages(ctx, m); err != nil {
-			c.log.Error("commit failed", zap.Error(err))
-			continue
+// decode parses and validates m. Invalid messages are rejected to the DLQ, in
+// which case the order is nil and done reports whether the rejection completed.
+func (c *Consumer) decode(ctx context.Context, m kgo.Message) (o *model.Order, done bool) {
+	o = &model.Order{}
+	if err := json.Unmarshal(m.Value, o); err != nil {
+		c.log.Warn("invalid message json, skip", zap.Error(err))
+		return nil, c.reject(ctx, c.dlq, m, rejection{reason: ReasonInvalidJSON, cause: err})
+	}
+
+	if validationErrors := o.Validate(); len(validationErrors) > 0 {
+		c.log.Warn("invalid order data, skip",
+			zap.String("order_uid", o.OrderUID),
+			zap.Any("validation_errors", validationErrors),
+		)
+		return nil, c.reject(ctx, c.dlq, m, rejection{reason: ReasonValidation, verrs: validationErrors})
+	}
+
+	if o.DateCreated.IsZero() {
+		c.log.Warn("order has zero date, using current time",
+			zap.String("order_uid", o.OrderUID),
+		)
+		o.DateCreated = time.Now()
+	}
+	return o, true
+}
+
+// storeFailed applies the consumer action for a store error that will not be
+// retried any more. It returns false if ctx is done.
+func (c *Consumer) storeFailed(ctx context.Context, m kgo.Message, o *model.Order, attempts int, err error) bool {
+	switch {
+	case ctx.Err() != nil:
+		return false
+	case errors.Is(err, errRetriesExhausted):
+		return c.reject(ctx, c.parking, m, rejection{reason: ReasonRetriesExhausted, cause: err, attempts: attempts})
+	case errs.KindOf(err).ConsumerAction() == errs.ActionSkip:
+		c.log.Warn("store order skipped",
+			zap.String("order_uid", o.OrderUID),
+			zap.Error(err),
+		)
+		return true
+	default:
+		reason := ReasonStorePermanent
+		var verrs model.ValidationErrors
+		if errs.Is(err, errs.KindValidation) {
+			reason = ReasonStoreValidation
+			errors.As(err, &verrs)
 		}
+		c.log.Warn("store order rejected, skip",
+			zap.String("order_uid", o.OrderUID),
+			zap.Stringer("kind", errs.KindOf(err)),
+			zap.Error(err),
+		)
+		return c.reject(ctx, c.dlq, m, rejection{reason: reason, cause: err, verrs: verrs})
 	}
 }
 
@@ -121,7 +334,7 @@ func (c *Consumer) ProcessMessageWithValidation(ctx context.Context, m kgo.Messa
 			zap.String("order_uid", o.OrderUID),
 			zap.Any("errors", validationErrors),
 		)
-		return errors.New("validation failed")
+		return errs.E(errs.KindValidation, "validate order", model.ValidationErrors(validationErrors))
 	}
 
 	if o.Payment.Amount <= 0 {
@@ -129,19 +342,21 @@ func (c *Consumer) ProcessMessageWithValidation(ctx context.Context, m kgo.Messa
 			zap.String("order_uid", o.OrderUID),
 			zap.Int("amount", o.Payment.Amount),
 		)
-		return errors.New("invalid payment amount")
+		return errs.New(errs.KindValidation, "invalid payment amount")
 	}
 
 	if len(o.Items) == 0 {
 		c.log.Warn("order has no items",
 			zap.String("order_uid", o.OrderUID),
 		)
-		return errors.New("no items in order")
+		return errs.New(errs.KindValidation, "no items in order")
 	}
 
-	if err := c.svc.Put(ctx, &o); err != nil {
+	res, err := c.svc.Put(ctx, &o, messageSource(m))
+	if err != nil {
 		return err
 	}
+	c.stored(m, &o, res)
 
 	return c.reader.CommitMessages(ctx, m)
 }
@@ -0,0 +1,130 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"wb-snilez-l0/internal/model"
+
+	kgo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// Headers attached to messages republished to the dead-letter or parking topic.
+const (
+	HeaderDLQReason          = "x-dlq-reason"
+	HeaderDLQErrors          = "x-dlq-errors"
+	HeaderDLQSourceTopic     = "x-dlq-source-topic"
+	HeaderDLQSourcePartition = "x-dlq-source-partition"
+	HeaderDLQSourceOffset    = "x-dlq-source-offset"
+	HeaderDLQTimestamp       = "x-dlq-timestamp"
+	HeaderDLQAttempts        = "x-dlq-attempts"
+)
+
+// Rejection reasons written to HeaderDLQReason.
+const (
+	ReasonInvalidJSON      = "invalid_json"
+	ReasonValidation       = "validation_failed"
+	ReasonStoreValidation  = "store_validation_failed"
+	ReasonStorePermanent   = "store_permanent_error"
+	ReasonRetriesExhausted = "retries_exhausted"
+)
+
+type rejection struct {
+	reason   string
+	cause    error
+	verrs    []model.ValidationError
+	attempts int
+}
+
+func newWriter(brokers []string, topic string, timeout time.Duration) *kgo.Writer {
+	if topic == "" {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &kgo.Writer{
+		Addr:         kgo.TCP(brokers...),
+		Topic:        topic,
+		RequiredAcks: kgo.RequireAll,
+		WriteTimeout: timeout,
+	}
+}
+
+// deadLetter republishes a rejected message to w as is, adding the rejection
+// reason and source coordinates to its headers. Without a writer the message
+// is only logged.
+func (c *Consumer) deadLetter(ctx context.Context, w *kgo.Writer, m kgo.Message, rj rejection) error {
+	fields := []zap.Field{
+		zap.String("reason", rj.reason),
+		zap.Int("partition", m.Partition),
+		zap.Int64("offset", m.Offset),
+	}
+	if rj.cause != nil {
+		fields = append(fields, zap.Error(rj.cause))
+	}
+	if len(rj.verrs) > 0 {
+		fields = append(fields, zap.Any("validation_errors", rj.verrs))
+	}
+	if rj.attempts > 0 {
+		fields = append(fields, zap.Int("attempts", rj.attempts))
+	}
+
+	if w == nil {
+		c.log.Warn("message rejected, dlq disabled", fields...)
+		c.m.MessageRejected(rj.reason)
+		return nil
+	}
+
+	var errText []byte
+	switch {
+	case len(rj.verrs) > 0:
+		errText, _ = json.Marshal(rj.verrs)
+	case rj.cause != nil:
+		errText = []byte(rj.cause.Error())
+	}
+
+	headers := make([]kgo.Header, 0, len(m.Headers)+7)
+	headers = append(headers, m.Headers...)
+	headers = append(headers,
+		kgo.Header{Key: HeaderDLQReason, Value: []byte(rj.reason)},
+		kgo.Header{Key: HeaderDLQErrors, Value: errText},
+		kgo.Header{Key: HeaderDLQSourceTopic, Value: []byte(m.Topic)},
+		kgo.Header{Key: HeaderDLQSourcePartition, Value: []byte(strconv.Itoa(m.Partition))},
+		kgo.Header{Key: HeaderDLQSourceOffset, Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		kgo.Header{Key: HeaderDLQTimestamp, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	)
+	if rj.attempts > 0 {
+		headers = append(headers, kgo.Header{Key: HeaderDLQAttempts, Value: []byte(strconv.Itoa(rj.attempts))})
+	}
+
+	if err := w.WriteMessages(ctx, kgo.Message{
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	}); err != nil {
+		c.log.Error("dlq write failed", append(fields, zap.String("topic", w.Topic), zap.NamedError("dlq_error", err))...)
+		return err
+	}
+
+	c.log.Warn("message sent to dlq", append(fields, zap.String("topic", w.Topic))...)
+	c.m.MessageRejected(rj.reason)
+	return nil
+}
+
+// reject publishes the message to w. It keeps trying until the write
+// succeeds, so a rejected order is never committed without being parked, and
+// returns false only if ctx is done first.
+func (c *Consumer) reject(ctx context.Context, w *kgo.Writer, m kgo.Message, rj rejection) bool {
+	for c.deadLetter(ctx, w, m, rj) != nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	kgo "github.com/segmentio/kafka-go"
+)
+
+// Ping checks that at least one broker accepts connections.
+func (c *Consumer) Ping(ctx context.Context) error {
+	var errs []error
+	for _, b := range c.brokers {
+		conn, err := kgo.DialContext(ctx, "tcp", b)
+		if err == nil {
+			return conn.Close()
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// CheckGroup reports whether the consumer group is stable and has members,
+// i.e. partitions are assigned and being consumed.
+func (c *Consumer) CheckGroup(ctx context.Context) error {
+	cl := &kgo.Client{Addr: kgo.TCP(c.brokers...)}
+	resp, err := cl.DescribeGroups(ctx, &kgo.DescribeGroupsRequest{GroupIDs: []string{c.groupID}})
+	if err != nil {
+		return err
+	}
+	for _, g := range resp.Groups {
+		if g.GroupID != c.groupID {
+			continue
+		}
+		if g.Error != nil {
+			return g.Error
+		}
+		if g.GroupState != "Stable" {
+			return fmt.Errorf("group %s is %s", c.groupID, g.GroupState)
+		}
+		if len(g.Members) == 0 {
+			return fmt.Errorf("group %s has no members", c.groupID)
+		}
+		return nil
+	}
+	return fmt.Errorf("group %s not found", c.groupID)
+}
@@ -0,0 +1,146 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	kgo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+
+	"wb-snilez-l0/internal/metrics"
+	"wb-snilez-l0/internal/repo"
+)
+
+// Headers of order change events.
+const (
+	HeaderEventID      = "x-event-id"
+	HeaderEventType    = "x-event-type"
+	HeaderEventVersion = "x-event-version"
+)
+
+type RelayConfig struct {
+	Brokers      []string
+	Topic        string
+	WriteTimeout time.Duration
+	BatchSize    int
+	PollInterval time.Duration
+	// Retention is how long sent events are kept in the outbox; cleanup runs
+	// every CleanupInterval.
+	Retention       time.Duration
+	CleanupInterval time.Duration
+}
+
+// Relay publishes the order_events outbox to Kafka. Events are keyed by
+// order_uid, so the events of one order land in one partition.
+type Relay struct {
+	repo *repo.PG
+	w    *kgo.Writer
+	log  *zap.Logger
+	m    *metrics.Metrics
+	cfg  RelayConfig
+}
+
+func NewRelay(cfg RelayConfig, r *repo.PG, log *zap.Logger, m *metrics.Metrics) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.CleanupInterval <= 0 {
+		cfg.CleanupInterval = time.Hour
+	}
+	w := newWriter(cfg.Brokers, cfg.Topic, cfg.WriteTimeout)
+	// Partition by key, so the events of one order stay in order.
+	w.Balancer = &kgo.Hash{}
+	return &Relay{
+		repo: r,
+		w:    w,
+		log:  log,
+		m:    m,
+		cfg:  cfg,
+	}
+}
+
+// Run relays events until ctx is done. Full batches are followed by the next
+// one right away; otherwise the relay polls every PollInterval. Sent events
+// are cleaned up on their own schedule, however busy the relay is.
+func (r *Relay) Run(ctx context.Context) error {
+	defer r.w.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.cleanupLoop(ctx)
+	}()
+	defer func() { <-done }()
+
+	poll := time.NewTicker(r.cfg.PollInterval)
+	defer poll.Stop()
+
+	for {
+		n, err := r.repo.RelayEvents(ctx, r.cfg.BatchSize, r.publish)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			r.log.Warn("relay order events", zap.Error(err))
+		case n > 0:
+			r.m.EventsPublished(n)
+		}
+		if n == r.cfg.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-poll.C:
+		}
+	}
+}
+
+func (r *Relay) cleanupLoop(ctx context.Context) {
+	if r.cfg.Retention <= 0 {
+		return
+	}
+	t := time.NewTicker(r.cfg.CleanupInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.cleanup(ctx)
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, events []repo.Event) error {
+	msgs := make([]kgo.Message, len(events))
+	for i, e := range events {
+		msgs[i] = kgo.Message{
+			Key:   []byte(e.OrderUID),
+			Value: e.Payload,
+			Time:  e.CreatedAt,
+			Headers: []kgo.Header{
+				{Key: HeaderEventID, Value: []byte(strconv.FormatInt(e.ID, 10))},
+				{Key: HeaderEventType, Value: []byte(e.Type)},
+				{Key: HeaderEventVersion, Value: []byte(strconv.FormatInt(e.Version, 10))},
+			},
+		}
+	}
+	return r.w.WriteMessages(ctx, msgs...)
+}
+
+func (r *Relay) cleanup(ctx context.Context) {
+	n, err := r.repo.DeleteSentEvents(ctx, time.Now().Add(-r.cfg.Retention))
+	if err != nil {
+		r.log.Warn("clean up order events", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		r.log.Info("order events cleaned up", zap.Int64("deleted", n))
+	}
+}
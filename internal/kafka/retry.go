@@ -0,0 +1,124 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"wb-snilez-l0/internal/errs"
+	"wb-snilez-l0/internal/model"
+	"wb-snilez-l0/internal/repo"
+
+	kgo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+var errRetriesExhausted = errors.New("retries exhausted")
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of the backoff randomized in both directions, 0..1.
+	Jitter float64
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 200 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 10 * time.Second
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	return p
+}
+
+// Backoff returns the delay before the given retry, attempt starting at 1.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// storeWithRetry calls svc.Put until it succeeds, fails with an error whose
+// kind is not retryable or the policy runs out of attempts. It returns the
+// outcome of the write, the number of attempts made and errRetriesExhausted
+// wrapping the last error in the latter case.
+func (c *Consumer) storeWithRetry(ctx context.Context, m kgo.Message, o *model.Order) (repo.Outcome, int, error) {
+	var err error
+	for attempt := 1; attempt <= c.retry.MaxAttempts; attempt++ {
+		var res repo.Outcome
+		res, err = c.svc.Put(ctx, o, messageSource(m))
+		if err == nil {
+			if attempt > 1 {
+				c.log.Info("store order succeeded after retry",
+					zap.String("order_uid", o.OrderUID),
+					zap.Int("attempts", attempt),
+				)
+			}
+			return res, attempt, nil
+		}
+		if errs.KindOf(err).ConsumerAction() != errs.ActionRetry || ctx.Err() != nil {
+			return 0, attempt, err
+		}
+		if attempt == c.retry.MaxAttempts {
+			break
+		}
+
+		delay := c.retry.Backoff(attempt)
+		c.m.MessageRetried(errs.KindOf(err).String())
+		c.log.Warn("store order failed, retrying",
+			zap.String("order_uid", o.OrderUID),
+			zap.Int("partition", m.Partition),
+			zap.Int64("offset", m.Offset),
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", c.retry.MaxAttempts),
+			zap.Duration("backoff", delay),
+			zap.Stringer("kind", errs.KindOf(err)),
+			zap.Error(err),
+		)
+		if serr := sleepCtx(ctx, delay); serr != nil {
+			return 0, attempt, serr
+		}
+	}
+
+	c.log.Error("store order failed, retries exhausted",
+		zap.String("order_uid", o.OrderUID),
+		zap.Int("partition", m.Partition),
+		zap.Int64("offset", m.Offset),
+		zap.Int("attempts", c.retry.MaxAttempts),
+		zap.Error(err),
+	)
+	return 0, c.retry.MaxAttempts, errors.Join(errRetriesExhausted, err)
+}
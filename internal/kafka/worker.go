@@ -0,0 +1,240 @@
+package kafka
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	kgo "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// Ordering modes for the worker pool.
+const (
+	// OrderByPartition keeps every partition on a single worker.
+	OrderByPartition = "partition"
+	// OrderByKey keeps every message key on a single worker, so one busy
+	// partition can be spread across workers.
+	OrderByKey = "key"
+)
+
+// Stats is a point-in-time view of the consumer's worker pool.
+type Stats struct {
+	Workers     int
+	QueueDepth  int
+	InFlight    int64
+	Processed   uint64
+	Uncommitted int
+}
+
+func (c *Consumer) registerMetrics() {
+	if c.m == nil {
+		return
+	}
+	c.m.GaugeFunc("kafka", "workers", "Configured consumer workers.", func() float64 {
+		return float64(c.workers)
+	})
+	c.m.GaugeFunc("kafka", "in_flight", "Messages being processed right now.", func() float64 {
+		return float64(c.inFlight.Load())
+	})
+	c.m.GaugeFunc("kafka", "queue_depth", "Messages waiting in worker queues.", func() float64 {
+		return float64(c.Stats().QueueDepth)
+	})
+	c.m.GaugeFunc("kafka", "uncommitted", "Fetched messages whose offsets are not committed yet.", func() float64 {
+		return float64(c.offsets.pending())
+	})
+	c.m.RegisterKafkaReader(c.reader.Stats)
+}
+
+func (c *Consumer) Stats() Stats {
+	s := Stats{
+		Workers:     c.workers,
+		InFlight:    c.inFlight.Load(),
+		Processed:   c.processed.Load(),
+		Uncommitted: c.offsets.pending(),
+	}
+	if p := c.pool.Load(); p != nil {
+		for _, ch := range p.queues {
+			s.QueueDepth += len(ch)
+		}
+	}
+	return s
+}
+
+type pool struct {
+	queues   []chan kgo.Message
+	wg       sync.WaitGroup
+	byKey    bool
+	stopOnce sync.Once
+}
+
+func (c *Consumer) startPool(ctx context.Context) *pool {
+	p := &pool{
+		queues: make([]chan kgo.Message, c.workers),
+		byKey:  c.ordering == OrderByKey,
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan kgo.Message, c.queueSize)
+		p.wg.Add(1)
+		go func(q <-chan kgo.Message) {
+			defer p.wg.Done()
+			if c.batchSize > 1 {
+				c.runBatches(ctx, q)
+				return
+			}
+			for m := range q {
+				c.inFlight.Add(1)
+				ok := c.process(ctx, m)
+				c.inFlight.Add(-1)
+				if !ok {
+					continue
+				}
+				c.processed.Add(1)
+				c.m.MessageProcessed()
+				if last := c.offsets.done(m); last != nil {
+					c.commit(ctx, *last)
+				}
+			}
+		}(p.queues[i])
+	}
+	c.pool.Store(p)
+	return p
+}
+
+// dispatch routes m to its worker, blocking while the worker's queue is full.
+func (p *pool) dispatch(ctx context.Context, m kgo.Message) bool {
+	idx := m.Partition
+	if p.byKey && len(m.Key) > 0 {
+		h := fnv.New32a()
+		_, _ = h.Write(m.Key)
+		idx = int(h.Sum32() & 0x7fffffff)
+	}
+	select {
+	case p.queues[idx%len(p.queues)] <- m:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stop lets workers drain their queues and waits for them.
+func (p *pool) stop() {
+	p.stopOnce.Do(func() {
+		for _, q := range p.queues {
+			close(q)
+		}
+		p.wg.Wait()
+	})
+}
+
+func (c *Consumer) runBatches(ctx context.Context, q <-chan kgo.Message) {
+	for {
+		batch := c.collect(q)
+		if batch == nil {
+			return
+		}
+		c.inFlight.Add(int64(len(batch)))
+		done := c.processBatch(ctx, batch)
+		c.inFlight.Add(-int64(len(batch)))
+
+		var toCommit []kgo.Message
+		for i, m := range batch {
+			if !done[i] {
+				continue
+			}
+			c.processed.Add(1)
+			c.m.MessageProcessed()
+			if last := c.offsets.done(m); last != nil {
+				toCommit = append(toCommit, *last)
+			}
+		}
+		c.commit(ctx, toCommit...)
+	}
+}
+
+// commit commits msgs in one request. It runs detached from ctx so that
+// messages finished during shutdown are still committed.
+func (c *Consumer) commit(ctx context.Context, msgs ...kgo.Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := c.reader.CommitMessages(cctx, msgs...); err != nil {
+		last := msgs[len(msgs)-1]
+		c.log.Error("commit failed",
+			zap.Int("messages", len(msgs)),
+			zap.Int("partition", last.Partition),
+			zap.Int64("offset", last.Offset),
+			zap.Error(err),
+		)
+	}
+}
+
+// offsetTracker remembers fetched messages per partition and hands out the
+// highest offset below which every message has been processed. Messages of a
+// partition may finish out of order when the pool routes by key.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	queue []kgo.Message
+	done  map[int64]struct{}
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+func (t *offsetTracker) track(m kgo.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	po, ok := t.partitions[m.Partition]
+	// An offset that goes backwards means the partition was reassigned and is
+	// being re-read from the committed position; forget the old window.
+	if !ok || (len(po.queue) > 0 && m.Offset <= po.queue[len(po.queue)-1].Offset) {
+		po = &partitionOffsets{done: make(map[int64]struct{})}
+		t.partitions[m.Partition] = po
+	}
+	po.queue = append(po.queue, m)
+}
+
+// done marks m as processed and returns the message to commit, or nil if an
+// earlier message of the partition is still in progress.
+func (t *offsetTracker) done(m kgo.Message) *kgo.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	po, ok := t.partitions[m.Partition]
+	if !ok {
+		return nil
+	}
+	if len(po.queue) == 0 || m.Offset < po.queue[0].Offset {
+		return nil
+	}
+	po.done[m.Offset] = struct{}{}
+
+	var last *kgo.Message
+	for len(po.queue) > 0 {
+		head := po.queue[0]
+		if _, ok := po.done[head.Offset]; !ok {
+			break
+		}
+		delete(po.done, head.Offset)
+		po.queue = po.queue[1:]
+		last = &head
+	}
+	return last
+}
+
+func (t *offsetTracker) pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, po := range t.partitions {
+		n += len(po.queue)
+	}
+	return n
+}
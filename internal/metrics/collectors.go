@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	kgo "github.com/segmentio/kafka-go"
+
+	"wb-snilez-l0/internal/cache"
+)
+
+func desc(subsystem, name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, nil, nil)
+}
+
+// cacheCollector exports cache.Stats read at scrape time.
+type cacheCollector struct {
+	stats func() cache.Stats
+
+	hits, staleHits, misses, evictions, expirations, deletions, replacements, rejections, size, capacity, cost, maxCost *prometheus.Desc
+}
+
+// RegisterCache exports the counters of a cache under the given name label.
+func (m *Metrics) RegisterCache(name string, stats func() cache.Stats) {
+	l := prometheus.Labels{"cache": name}
+	d := func(n, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "cache", n), help, nil, l)
+	}
+	m.MustRegister(&cacheCollector{
+		stats:        stats,
+		hits:         d("hits_total", "Cache lookups that found a live entry."),
+		staleHits:    d("stale_hits_total", "Cache lookups served an expired entry within the grace period."),
+		misses:       d("misses_total", "Cache lookups that found nothing or an expired entry."),
+		evictions:    d("evictions_total", "Entries evicted to stay within capacity."),
+		expirations:  d("expirations_total", "Entries removed because their TTL passed."),
+		deletions:    d("deletions_total", "Entries removed explicitly."),
+		replacements: d("replacements_total", "Entries overwritten with a new value."),
+		rejections:   d("rejections_total", "New entries refused by the admission policy or as too large."),
+		size:         d("entries", "Entries currently held."),
+		capacity:     d("capacity", "Configured maximum number of entries."),
+		cost:         d("cost", "Summed cost of the entries, in bytes for cost-bounded caches."),
+		maxCost:      d("max_cost", "Configured cost budget; 0 if the cache is bounded by entry count only."),
+	})
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.staleHits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expirations
+	ch <- c.deletions
+	ch <- c.replacements
+	ch <- c.rejections
+	ch <- c.size
+	ch <- c.capacity
+	ch <- c.cost
+	ch <- c.maxCost
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(c.staleHits, prometheus.CounterValue, float64(s.StaleHits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(s.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(s.Expirations))
+	ch <- prometheus.MustNewConstMetric(c.deletions, prometheus.CounterValue, float64(s.Deletions))
+	ch <- prometheus.MustNewConstMetric(c.replacements, prometheus.CounterValue, float64(s.Replacements))
+	ch <- prometheus.MustNewConstMetric(c.rejections, prometheus.CounterValue, float64(s.Rejections))
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(s.Size))
+	ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(s.Capacity))
+	ch <- prometheus.MustNewConstMetric(c.cost, prometheus.GaugeValue, float64(s.Cost))
+	ch <- prometheus.MustNewConstMetric(c.maxCost, prometheus.GaugeValue, float64(s.MaxCost))
+}
+
+// poolCollector exports pgxpool.Stat.
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired, idle, total, max, acquires, acquireDur, emptyAcquires, canceled *prometheus.Desc
+}
+
+func (m *Metrics) RegisterPool(pool *pgxpool.Pool) {
+	m.MustRegister(&poolCollector{
+		pool:          pool,
+		acquired:      desc("db_pool", "acquired_conns", "Connections currently in use."),
+		idle:          desc("db_pool", "idle_conns", "Idle connections."),
+		total:         desc("db_pool", "total_conns", "Open connections."),
+		max:           desc("db_pool", "max_conns", "Maximum pool size."),
+		acquires:      desc("db_pool", "acquires_total", "Successful connection acquires."),
+		acquireDur:    desc("db_pool", "acquire_duration_seconds_total", "Time spent acquiring connections."),
+		emptyAcquires: desc("db_pool", "empty_acquires_total", "Acquires that had to wait for a connection."),
+		canceled:      desc("db_pool", "canceled_acquires_total", "Acquires canceled by their context."),
+	})
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquires
+	ch <- c.acquireDur
+	ch <- c.emptyAcquires
+	ch <- c.canceled
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDur, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceled, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+}
+
+// readerCollector exports kafka-go reader stats. Reader.Stats resets its
+// counters on every call, so they are accumulated here.
+type readerCollector struct {
+	stats func() kgo.ReaderStats
+
+	mu                                      sync.Mutex
+	messages, errors, rebalances, timeouts  int64
+	descMessages, descErrors, descRebalance *prometheus.Desc
+	descTimeouts, descLag, descQueue        *prometheus.Desc
+}
+
+func (m *Metrics) RegisterKafkaReader(stats func() kgo.ReaderStats) {
+	m.MustRegister(&readerCollector{
+		stats:         stats,
+		descMessages:  desc("kafka_reader", "messages_total", "Messages read by the kafka-go reader."),
+		descErrors:    desc("kafka_reader", "errors_total", "Reader errors."),
+		descRebalance: desc("kafka_reader", "rebalances_total", "Consumer group rebalances."),
+		descTimeouts:  desc("kafka_reader", "timeouts_total", "Reader timeouts."),
+		descLag:       desc("kafka_reader", "lag", "Lag reported by the reader for its current partition."),
+		descQueue:     desc("kafka_reader", "queue_length", "Messages buffered inside the reader."),
+	})
+}
+
+func (c *readerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.descMessages
+	ch <- c.descErrors
+	ch <- c.descRebalance
+	ch <- c.descTimeouts
+	ch <- c.descLag
+	ch <- c.descQueue
+}
+
+func (c *readerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats()
+	c.messages += s.Messages
+	c.errors += s.Errors
+	c.rebalances += s.Rebalances
+	c.timeouts += s.Timeouts
+	ch <- prometheus.MustNewConstMetric(c.descMessages, prometheus.CounterValue, float64(c.messages))
+	ch <- prometheus.MustNewConstMetric(c.descErrors, prometheus.CounterValue, float64(c.errors))
+	ch <- prometheus.MustNewConstMetric(c.descRebalance, prometheus.CounterValue, float64(c.rebalances))
+	ch <- prometheus.MustNewConstMetric(c.descTimeouts, prometheus.CounterValue, float64(c.timeouts))
+	ch <- prometheus.MustNewConstMetric(c.descLag, prometheus.GaugeValue, float64(s.Lag))
+	ch <- prometheus.MustNewConstMetric(c.descQueue, prometheus.GaugeValue, float64(s.QueueLength))
+}
@@ -0,0 +1,222 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "orders"
+
+// Metrics owns the Prometheus registry of the service and the collectors that
+// components report to. All recording methods are safe to call on a nil
+// *Metrics, which turns them into no-ops.
+type Metrics struct {
+	reg *prometheus.Registry
+
+	kafkaConsumed  prometheus.Counter
+	kafkaProcessed prometheus.Counter
+	kafkaRejected  *prometheus.CounterVec
+	kafkaRetried   *prometheus.CounterVec
+	kafkaStored    *prometheus.CounterVec
+	kafkaLag       *prometheus.GaugeVec
+
+	repoQuery        *prometheus.HistogramVec
+	repoWriteSkipped *prometheus.CounterVec
+
+	eventsPublished prometheus.Counter
+
+	cacheRefreshes    *prometheus.CounterVec
+	cacheInvalidation *prometheus.HistogramVec
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		reg: prometheus.NewRegistry(),
+		kafkaConsumed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "messages_consumed_total",
+			Help: "Messages fetched from Kafka.",
+		}),
+		kafkaProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "messages_processed_total",
+			Help: "Messages fully handled and eligible for commit.",
+		}),
+		kafkaRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "messages_rejected_total",
+			Help: "Messages sent to the dead-letter or parking topic, by reason.",
+		}, []string{"reason"}),
+		kafkaRetried: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "messages_retried_total",
+			Help: "Store retries, by error kind.",
+		}, []string{"reason"}),
+		kafkaStored: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "messages_stored_total",
+			Help: "Orders from Kafka handed to the store, by outcome (applied, stale, duplicate, unchanged).",
+		}, []string{"outcome"}),
+		kafkaLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "consumer_lag",
+			Help: "Messages behind the partition high water mark as of the last fetch.",
+		}, []string{"partition"}),
+		repoQuery: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "repo", Name: "query_duration_seconds",
+			Help:    "Repository operation latency.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"op", "status"}),
+		repoWriteSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "repo", Name: "writes_skipped_total",
+			Help: "Order writes not applied, by reason (stale, duplicate, unchanged).",
+		}, []string{"reason"}),
+		eventsPublished: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "outbox", Name: "events_published_total",
+			Help: "Order change events published from the outbox to Kafka.",
+		}),
+		cacheRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "cache", Name: "refreshes_total",
+			Help: "Background reloads of cached orders, by trigger (stale, ahead) and status.",
+		}, []string{"trigger", "status"}),
+		cacheInvalidation: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "cache", Name: "invalidation_lag_seconds",
+			Help:    "Time from a write on another instance to its invalidation here, by operation.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"op"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "http", Name: "requests_total",
+			Help: "HTTP requests, by route and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "http", Name: "request_duration_seconds",
+			Help:    "HTTP request latency, by route and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+	m.reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.kafkaConsumed, m.kafkaProcessed, m.kafkaRejected, m.kafkaRetried, m.kafkaStored, m.kafkaLag,
+		m.repoQuery, m.repoWriteSkipped,
+		m.eventsPublished,
+		m.cacheRefreshes, m.cacheInvalidation,
+		m.httpRequests, m.httpDuration,
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{Registry: m.reg})
+}
+
+// MustRegister adds collectors owned by other components to the registry.
+func (m *Metrics) MustRegister(cs ...prometheus.Collector) {
+	if m == nil {
+		return
+	}
+	m.reg.MustRegister(cs...)
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at scrape time.
+func (m *Metrics) GaugeFunc(subsystem, name, help string, fn func() float64) {
+	m.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: subsystem, Name: name, Help: help,
+	}, fn))
+}
+
+func (m *Metrics) MessageConsumed(partition int, offset, highWaterMark int64) {
+	if m == nil {
+		return
+	}
+	m.kafkaConsumed.Inc()
+	if highWaterMark > 0 {
+		m.kafkaLag.WithLabelValues(strconv.Itoa(partition)).Set(float64(highWaterMark - offset - 1))
+	}
+}
+
+func (m *Metrics) MessageProcessed() {
+	if m == nil {
+		return
+	}
+	m.kafkaProcessed.Inc()
+}
+
+func (m *Metrics) MessageRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.kafkaRejected.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) MessageRetried(reason string) {
+	if m == nil {
+		return
+	}
+	m.kafkaRetried.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) MessageStored(outcome string) {
+	if m == nil {
+		return
+	}
+	m.kafkaStored.WithLabelValues(outcome).Inc()
+}
+
+// ObserveQuery records the latency of a repository operation started at start.
+func (m *Metrics) ObserveQuery(op string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.repoQuery.WithLabelValues(op, status).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) WriteSkipped(reason string) {
+	if m == nil {
+		return
+	}
+	m.repoWriteSkipped.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) EventsPublished(n int) {
+	if m == nil {
+		return
+	}
+	m.eventsPublished.Add(float64(n))
+}
+
+// CacheRefreshed counts a background reload of a cached entry.
+func (m *Metrics) CacheRefreshed(trigger string, err error) {
+	if m == nil {
+		return
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.cacheRefreshes.WithLabelValues(trigger, status).Inc()
+}
+
+// CacheInvalidated records the lag of an invalidation of a write made at.
+func (m *Metrics) CacheInvalidated(op string, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.cacheInvalidation.WithLabelValues(op).Observe(max(0, time.Since(at).Seconds()))
+}
+
+func (m *Metrics) ObserveHTTP(method, route string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	code := strconv.Itoa(status)
+	m.httpRequests.WithLabelValues(method, route, code).Inc()
+	m.httpDuration.WithLabelValues(method, route, code).Observe(d.Seconds())
+}
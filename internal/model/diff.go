@@ -0,0 +1,67 @@
+package model
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldChange is one difference between two versions of an order. Field is
+// the JSON path of the value, e.g. "delivery.city" or "items[1].price"; an
+// item added or removed as a whole has no Old or New respectively.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+// Diff lists the fields that differ between orders a and b. Items are
+// compared by position.
+func Diff(a, b *Order) []FieldChange {
+	if a == nil || b == nil {
+		return nil
+	}
+	var res []FieldChange
+	diffValue(&res, "", reflect.ValueOf(*a), reflect.ValueOf(*b))
+	return res
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+func diffValue(res *[]FieldChange, path string, a, b reflect.Value) {
+	switch {
+	case a.Type() == timeType:
+		if ta, tb := a.Interface().(time.Time), b.Interface().(time.Time); !ta.Equal(tb) {
+			*res = append(*res, FieldChange{Field: path, Old: ta, New: tb})
+		}
+	case a.Kind() == reflect.Struct:
+		t := a.Type()
+		for i := range t.NumField() {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			diffValue(res, name, a.Field(i), b.Field(i))
+		}
+	case a.Kind() == reflect.Slice:
+		for i := range max(a.Len(), b.Len()) {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= a.Len():
+				*res = append(*res, FieldChange{Field: p, New: b.Index(i).Interface()})
+			case i >= b.Len():
+				*res = append(*res, FieldChange{Field: p, Old: a.Index(i).Interface()})
+			default:
+				diffValue(res, p, a.Index(i), b.Index(i))
+			}
+		}
+	default:
+		if !a.Equal(b) {
+			*res = append(*res, FieldChange{Field: path, Old: a.Interface(), New: b.Interface()})
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Hash returns a canonical SHA-256 of the order content: the JSON encoding
+// of o with DateCreated in UTC, so that equal orders hash equally whatever
+// time zone they arrived in.
+func (o *Order) Hash() (string, error) {
+	c := *o
+	c.DateCreated = c.DateCreated.UTC()
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -5,6 +5,9 @@ import (
 	"regexp"
 	"strings"
 	"time"
+	"unsafe"
+
+	"wb-snilez-l0/internal/errs"
 )
 
 type Delivery struct {
@@ -61,6 +64,28 @@ type Order struct {
 	OofShard          string    `json:"oof_shard"`
 }
 
+// Size approximates the memory held by o in bytes: the struct headers plus
+// the string and slice contents they point to.
+func (o *Order) Size() int64 {
+	n := int(unsafe.Sizeof(*o)) +
+		len(o.OrderUID) + len(o.TrackNumber) + len(o.Entry) + len(o.Locale) +
+		len(o.InternalSignature) + len(o.CustomerID) + len(o.DeliveryService) +
+		len(o.ShardKey) + len(o.OofShard)
+
+	d := &o.Delivery
+	n += len(d.Name) + len(d.Phone) + len(d.ZIP) + len(d.City) + len(d.Address) + len(d.Region) + len(d.Email)
+
+	p := &o.Payment
+	n += len(p.Transaction) + len(p.RequestID) + len(p.Currency) + len(p.Provider) + len(p.Bank)
+
+	n += cap(o.Items) * int(unsafe.Sizeof(Item{}))
+	for i := range o.Items {
+		it := &o.Items[i]
+		n += len(it.TrackNumber) + len(it.RID) + len(it.Name) + len(it.Size) + len(it.Brand)
+	}
+	return int64(n)
+}
+
 type ValidationError struct {
 	Field   string `json:"field"`
 	Message string `json:"message"`
@@ -70,6 +95,20 @@ func (e ValidationError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Field, e.Message)
 }
 
+// ValidationErrors carries the full list of validation failures through error
+// chains so that callers can recover it with errors.As.
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, err := range v {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (v ValidationErrors) Kind() errs.Kind { return errs.KindValidation }
+
 func (d Delivery) Validate() []ValidationError {
 	var errors []ValidationError
 
@@ -284,10 +323,5 @@ func (o Order) GetValidationError() error {
 		return nil
 	}
 
-	var errorMessages []string
-	for _, err := range errors {
-		errorMessages = append(errorMessages, err.Error())
-	}
-
-	return fmt.Errorf("validation failed: %s", strings.Join(errorMessages, "; "))
+	return fmt.Errorf("validation failed: %w", ValidationErrors(errors))
 }
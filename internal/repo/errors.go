@@ -0,0 +1,57 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"wb-snilez-l0/internal/errs"
+)
+
+var ErrNotFound = errs.New(errs.KindNotFound, "not found")
+var ErrValidation = errs.New(errs.KindValidation, "validation error")
+var ErrExists = errs.New(errs.KindConflict, "order already exists")
+
+// classify attaches an errs.Kind to a database error returned by op.
+// Errors that already carry a kind are returned unchanged.
+func classify(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var k interface{ Kind() errs.Kind }
+	if errors.As(err, &k) {
+		return err
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return errs.E(errs.KindTransient, op, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return errs.E(pgErrorKind(pgErr.Code), op, err)
+	}
+	// Anything else from the driver is a connection-level failure.
+	return errs.E(errs.KindTransient, op, err)
+}
+
+// pgErrorKind maps a Postgres SQLSTATE code to an error kind.
+func pgErrorKind(code string) errs.Kind {
+	switch {
+	case code == "23505": // unique_violation
+		return errs.KindConflict
+	case strings.HasPrefix(code, "23"), strings.HasPrefix(code, "22"): // integrity, data exception
+		return errs.KindValidation
+	case strings.HasPrefix(code, "40"): // serialization failure, deadlock
+		return errs.KindConflict
+	case strings.HasPrefix(code, "08"), strings.HasPrefix(code, "53"), strings.HasPrefix(code, "57P"):
+		return errs.KindTransient
+	default:
+		return errs.KindPermanent
+	}
+}
@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"wb-snilez-l0/internal/errs"
+	"wb-snilez-l0/internal/model"
+)
+
+// Operations recorded in order_history.
+const (
+	HistoryCreated = "created"
+	HistoryUpdated = "updated"
+	HistoryDeleted = "deleted"
+)
+
+// Revision is a version of an order as recorded in order_history. Order is
+// nil for a deletion.
+type Revision struct {
+	Version   int64        `json:"version"`
+	Op        string       `json:"op"`
+	Source    Source       `json:"source"`
+	ChangedAt time.Time    `json:"changed_at"`
+	Order     *model.Order `json:"order,omitempty"`
+}
+
+const revisionColumns = `version, op, raw_json, source_kind, source_ref, source_version, source_caller, changed_at`
+
+func scanRevision(row pgx.CollectableRow) (Revision, error) {
+	var (
+		r   Revision
+		raw []byte
+	)
+	if err := row.Scan(&r.Version, &r.Op, &raw, &r.Source.Kind, &r.Source.Ref, &r.Source.Version, &r.Source.Caller, &r.ChangedAt); err != nil {
+		return r, classify("scan revision", err)
+	}
+	if raw != nil {
+		r.Order = &model.Order{}
+		if err := json.Unmarshal(raw, r.Order); err != nil {
+			return r, errs.E(errs.KindPermanent, "unmarshal history raw_json", err)
+		}
+	}
+	return r, nil
+}
+
+// OrderHistory returns every recorded version of the order, oldest first, or
+// ErrNotFound if there is none.
+func (p *PG) OrderHistory(ctx context.Context, uid string) (_ []Revision, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("order_history", start, err) }(time.Now())
+
+	rows, err := p.db.Query(ctx, `SELECT `+revisionColumns+` FROM order_history WHERE order_uid=$1 ORDER BY version`, uid)
+	if err != nil {
+		return nil, classify("select history", err)
+	}
+	res, err := pgx.CollectRows(rows, scanRevision)
+	if err != nil {
+		return nil, classify("select history", err)
+	}
+	if len(res) == 0 {
+		return nil, ErrNotFound
+	}
+	return res, nil
+}
+
+// OrderRevision returns the given version of the order and the order as of
+// the version before it, nil if there is none.
+func (p *PG) OrderRevision(ctx context.Context, uid string, version int64) (_ Revision, prev *model.Order, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("order_revision", start, err) }(time.Now())
+
+	rows, err := p.db.Query(ctx, `
+		SELECT `+revisionColumns+`
+		FROM order_history
+		WHERE order_uid=$1 AND version <= $2
+		ORDER BY version DESC
+		LIMIT 2
+	`, uid, version)
+	if err != nil {
+		return Revision{}, nil, classify("select revision", err)
+	}
+	res, err := pgx.CollectRows(rows, scanRevision)
+	if err != nil {
+		return Revision{}, nil, classify("select revision", err)
+	}
+	if len(res) == 0 || res[0].Version != version {
+		return Revision{}, nil, ErrNotFound
+	}
+	if len(res) == 2 {
+		prev = res[1].Order
+	}
+	return res[0], prev, nil
+}
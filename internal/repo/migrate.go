@@ -1,7 +1,6 @@
 package repo
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -42,8 +41,3 @@ func RunMigrations(dsn string, up bool) error {
 	}
 	return nil
 }
-
-func Ping(ctx context.Context, dsn string) error {
-	_ = ctx
-	return nil
-}
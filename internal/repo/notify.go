@@ -0,0 +1,110 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"wb-snilez-l0/internal/log"
+)
+
+// ChangesChannel is the Postgres notification channel on which every write
+// announces the orders it changed, so that all replicas can invalidate their
+// caches.
+const ChangesChannel = "orders_changed"
+
+// Change operations.
+const (
+	OpUpsert = "upsert"
+	OpDelete = "delete"
+)
+
+// Change is the payload of a notification on ChangesChannel. Notifications
+// are sent from the writing transaction and delivered only if it commits.
+type Change struct {
+	OrderUID string `json:"uid"`
+	Op       string `json:"op"`
+	// TrackNumber and Transaction are the secondary keys of an upserted
+	// order, so that replicas can forget not-found lookups by them.
+	TrackNumber string `json:"track,omitempty"`
+	Transaction string `json:"tx,omitempty"`
+	// At is the time of the write in unix nanoseconds, for lag measurement.
+	At int64 `json:"ts"`
+	// Origin is the instance ID of the writer.
+	Origin string `json:"origin"`
+}
+
+func (c Change) Time() time.Time { return time.Unix(0, c.At) }
+
+func newInstanceID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// InstanceID identifies this process in the changes it publishes.
+func (p *PG) InstanceID() string { return p.instance }
+
+// queueNotify adds the notification of c to b.
+func (p *PG) queueNotify(b *pgx.Batch, c Change) {
+	c.At, c.Origin = time.Now().UnixNano(), p.instance
+	payload, _ := json.Marshal(c)
+	b.Queue(`SELECT pg_notify($1, $2)`, ChangesChannel, string(payload))
+}
+
+// listenRetry is the pause before reconnecting a failed listener.
+const listenRetry = time.Second
+
+// ListenChanges calls fn for every change written by other instances until
+// ctx is done. It holds one pooled connection for the duration and
+// reconnects when it breaks; changes committed while reconnecting are lost,
+// which the cache TTL bounds.
+func (p *PG) ListenChanges(ctx context.Context, fn func(Change)) error {
+	l := log.FromContext(ctx)
+	for {
+		err := p.listen(ctx, fn)
+		if ctx.Err() != nil {
+			return nil
+		}
+		l.Warn("changes listener failed, reconnecting", zap.Error(err))
+		select {
+		case <-time.After(listenRetry):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *PG) listen(ctx context.Context, fn func(Change)) error {
+	conn, err := p.db.Acquire(ctx)
+	if err != nil {
+		return classify("acquire", err)
+	}
+	// The connection is LISTENing; don't hand it back to the pool.
+	pc := conn.Hijack()
+	defer pc.Close(context.WithoutCancel(ctx))
+
+	if _, err := pc.Exec(ctx, "LISTEN "+pgx.Identifier{ChangesChannel}.Sanitize()); err != nil {
+		return classify("listen", err)
+	}
+	for {
+		n, err := pc.WaitForNotification(ctx)
+		if err != nil {
+			return classify("wait for notification", err)
+		}
+		var c Change
+		if err := json.Unmarshal([]byte(n.Payload), &c); err != nil {
+			log.FromContext(ctx).Warn("malformed change notification", zap.String("payload", n.Payload), zap.Error(err))
+			continue
+		}
+		if c.Origin == p.instance {
+			continue
+		}
+		fn(c)
+	}
+}
@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"wb-snilez-l0/internal/metrics"
+)
+
+// fakeListenServer accepts one connection, answers the startup and the
+// LISTEN and then sends payloads as notifications on ChangesChannel.
+func fakeListenServer(t *testing.T, payloads ...string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		be := pgproto3.NewBackend(conn, conn)
+		if _, err := be.ReceiveStartupMessage(); err != nil {
+			t.Errorf("startup: %v", err)
+			return
+		}
+		be.Send(&pgproto3.AuthenticationOk{})
+		be.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+		be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		if err := be.Flush(); err != nil {
+			return
+		}
+
+		msg, err := be.Receive()
+		if err != nil {
+			t.Errorf("receive: %v", err)
+			return
+		}
+		if q, ok := msg.(*pgproto3.Query); !ok || q.String != `LISTEN "`+ChangesChannel+`"` {
+			t.Errorf("got %#v, want LISTEN", msg)
+			return
+		}
+		be.Send(&pgproto3.CommandComplete{CommandTag: []byte("LISTEN")})
+		be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		for _, p := range payloads {
+			be.Send(&pgproto3.NotificationResponse{PID: 2, Channel: ChangesChannel, Payload: p})
+		}
+		if err := be.Flush(); err != nil {
+			return
+		}
+		// Hold the connection until the listener closes it.
+		for {
+			if _, err := be.Receive(); err != nil {
+				return
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestListenChanges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := &PG{m: metrics.New(), instance: "self"}
+	payload := func(c Change) string {
+		b, _ := json.Marshal(c)
+		return string(b)
+	}
+	want := Change{OrderUID: "b563feb7b2b84b6test", Op: OpUpsert, TrackNumber: "WBILMTESTTRACK", Transaction: "tx1", At: 1, Origin: "other"}
+	addr := fakeListenServer(t,
+		payload(Change{OrderUID: "own", Op: OpUpsert, Origin: "self"}),
+		"not json",
+		payload(want),
+	)
+
+	db, err := pgxpool.New(ctx, "postgres://orders@"+addr+"/orders?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	p.db = db
+
+	got := make(chan Change, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.ListenChanges(ctx, func(c Change) { got <- c })
+	}()
+
+	select {
+	case c := <-got:
+		if c != want {
+			t.Fatalf("got change %+v, want %+v", c, want)
+		}
+		cancel()
+	case <-ctx.Done():
+		t.Fatal("no change delivered")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ListenChanges: %v", err)
+	}
+}
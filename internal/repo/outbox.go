@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Event types written to the order_events outbox.
+const (
+	EventOrderCreated = "order.created"
+	EventOrderUpdated = "order.updated"
+	EventOrderDeleted = "order.deleted"
+)
+
+// Event is a row of the order_events outbox. Version is the order version the
+// event produced; consumers can use it to drop events that arrive out of
+// order.
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	OrderUID  string          `json:"order_uid"`
+	Version   int64           `json:"version"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// RelayEvents locks up to limit unsent events, oldest first, passes them to
+// publish and marks them sent if it succeeds, all in one transaction. Rows
+// locked by another relay are skipped, so several instances can relay
+// concurrently; an event is published at least once, and again if the
+// transaction fails after publish.
+func (p *PG) RelayEvents(ctx context.Context, limit int, publish func(context.Context, []Event) error) (n int, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("relay_events", start, err) }(time.Now())
+
+	tx, err := p.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, classify("begin tx", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, event_type, order_uid, version, payload, created_at
+		FROM order_events
+		WHERE sent_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return 0, classify("select events", err)
+	}
+	events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Event, error) {
+		var e Event
+		err := row.Scan(&e.ID, &e.Type, &e.OrderUID, &e.Version, &e.Payload, &e.CreatedAt)
+		return e, err
+	})
+	if err != nil {
+		return 0, classify("scan events", err)
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	if err := publish(ctx, events); err != nil {
+		return 0, err
+	}
+
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	if _, err := tx.Exec(ctx, `UPDATE order_events SET sent_at = now() WHERE id = ANY($1)`, ids); err != nil {
+		return 0, classify("mark events sent", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, classify("commit", err)
+	}
+	return len(events), nil
+}
+
+// DeleteSentEvents removes events sent before the given time.
+func (p *PG) DeleteSentEvents(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("delete_sent_events", start, err) }(time.Now())
+
+	tag, err := p.db.Exec(ctx, `DELETE FROM order_events WHERE sent_at < $1`, before)
+	if err != nil {
+		return 0, classify("delete sent events", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -3,145 +3,354 @@ package repo
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"time"
 
+	"wb-snilez-l0/internal/errs"
+	"wb-snilez-l0/internal/log"
+	"wb-snilez-l0/internal/metrics"
 	"wb-snilez-l0/internal/model"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
 )
 
-var ErrNotFound = errors.New("not found")
-var ErrValidation = errors.New("validation error")
+type PG struct {
+	db       *pgxpool.Pool
+	m        *metrics.Metrics
+	instance string
+}
 
-type PG struct{ db *pgxpool.Pool }
+func New(db *pgxpool.Pool, m *metrics.Metrics) *PG {
+	return &PG{db: db, m: m, instance: newInstanceID()}
+}
 
-func New(db *pgxpool.Pool) *PG { return &PG{db: db} }
+// Ping acquires a pooled connection and round-trips to Postgres.
+func (p *PG) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("ping", start, err) }(time.Now())
+	return classify("ping", p.db.Ping(ctx))
+}
 
-func (p *PG) UpsertOrder(ctx context.Context, o *model.Order) error {
-	if validationErrors := o.Validate(); len(validationErrors) > 0 {
-		return fmt.Errorf("%w: %v", ErrValidation, validationErrors)
-	}
+const (
+	// upsertOrderSQL bumps the order version and records the change in the
+	// order_events outbox and in order_history in the same statement.
+	// version counts the writes of the order and goes on from its history if
+	// it was deleted before; source_version orders the writes by their source
+	// and is checked by UpsertOrders before the write.
+	upsertOrderSQL = `
+		WITH up AS (
+		  INSERT INTO orders(order_uid, track_number, entry, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, raw_json, source_version, source_ref, content_hash, version)
+		  VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$16,
+		    (SELECT COALESCE(max(version), 0) + 1 FROM order_history WHERE order_uid = $1))
+		  ON CONFLICT (order_uid) DO UPDATE SET
+		    track_number=EXCLUDED.track_number, entry=EXCLUDED.entry, locale=EXCLUDED.locale,
+		    internal_signature=EXCLUDED.internal_signature, customer_id=EXCLUDED.customer_id,
+		    delivery_service=EXCLUDED.delivery_service, shardkey=EXCLUDED.shardkey, sm_id=EXCLUDED.sm_id,
+		    date_created=EXCLUDED.date_created, oof_shard=EXCLUDED.oof_shard, raw_json=EXCLUDED.raw_json,
+		    source_version=EXCLUDED.source_version, source_ref=EXCLUDED.source_ref, content_hash=EXCLUDED.content_hash,
+		    version=orders.version+1
+		  RETURNING order_uid, version, xmax = 0 AS inserted
+		), ev AS (
+		  INSERT INTO order_events(event_type, order_uid, version, payload)
+		  SELECT CASE WHEN inserted THEN '` + EventOrderCreated + `' ELSE '` + EventOrderUpdated + `' END, order_uid, version, $12
+		  FROM up
+		)
+		INSERT INTO order_history(order_uid, version, op, raw_json, source_kind, source_ref, source_version, source_caller)
+		SELECT order_uid, version, CASE WHEN inserted THEN '` + HistoryCreated + `' ELSE '` + HistoryUpdated + `' END, $12, $15, $14, $13, $17
+		FROM up
+	`
+	upsertDeliverySQL = `
+		INSERT INTO deliveries(order_uid, name, phone, zip, city, address, region, email)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+		ON CONFLICT (order_uid) DO UPDATE SET
+		  name=EXCLUDED.name, phone=EXCLUDED.phone, zip=EXCLUDED.zip, city=EXCLUDED.city,
+		  address=EXCLUDED.address, region=EXCLUDED.region, email=EXCLUDED.email
+	`
+	upsertPaymentSQL = `
+		INSERT INTO payments(order_uid, transaction, request_id, currency, provider, amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT (order_uid) DO UPDATE SET
+		  transaction=EXCLUDED.transaction, request_id=EXCLUDED.request_id, currency=EXCLUDED.currency, provider=EXCLUDED.provider,
+		  amount=EXCLUDED.amount, payment_dt=EXCLUDED.payment_dt, bank=EXCLUDED.bank, delivery_cost=EXCLUDED.delivery_cost,
+		  goods_total=EXCLUDED.goods_total, custom_fee=EXCLUDED.custom_fee
+	`
+)
 
-	raw, err := json.Marshal(o)
+var itemColumns = []string{"order_uid", "chrt_id", "track_number", "price", "rid", "name", "sale", "size", "total_price", "nm_id", "brand", "status"}
+
+func (p *PG) UpsertOrder(ctx context.Context, o *model.Order, src Source) (Outcome, error) {
+	res, err := p.UpsertOrders(ctx, []Write{{Order: o, Source: src}})
 	if err != nil {
-		return fmt.Errorf("marshal order: %w", err)
+		return 0, err
+	}
+	return res[0], nil
+}
+
+// UpsertOrders writes all orders in one transaction: the order, delivery and
+// payment upserts go in a single pgx.Batch and items are replaced with COPY.
+// A write older than the stored order is refused, as is a repeated one or a
+// redelivered Kafka message, and a write whose content equals the stored
+// order only updates its source version; the outcome of every write is
+// returned in its slot. If an order_uid repeats, the newest write wins.
+func (p *PG) UpsertOrders(ctx context.Context, writes []Write) (res []Outcome, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("upsert_orders", start, err) }(time.Now())
+	return p.upsertOrders(ctx, writes, false)
+}
+
+// CreateOrder stores a new order and fails with ErrExists if one with the
+// same uid is stored. The check runs under the same per-order lock as the
+// write, so concurrent creates of one order can't both succeed.
+func (p *PG) CreateOrder(ctx context.Context, o *model.Order, src Source) (err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("create_order", start, err) }(time.Now())
+	_, err = p.upsertOrders(ctx, []Write{{Order: o, Source: src}}, true)
+	return err
+}
+
+// upsertOrders implements UpsertOrders; with create it fails with ErrExists
+// if any of the orders is stored.
+func (p *PG) upsertOrders(ctx context.Context, writes []Write, create bool) (res []Outcome, err error) {
+	res = make([]Outcome, len(writes))
+	if len(writes) == 0 {
+		return res, nil
+	}
+	var uids []string
+	seen := make(map[string]bool, len(writes))
+	for i, w := range writes {
+		writes[i].Source = withVersion(w.Source)
+		if validationErrors := w.Order.Validate(); len(validationErrors) > 0 {
+			return nil, fmt.Errorf("%w: %w", ErrValidation, model.ValidationErrors(validationErrors))
+		}
+		if !seen[w.Order.OrderUID] {
+			seen[w.Order.OrderUID] = true
+			uids = append(uids, w.Order.OrderUID)
+		}
 	}
 
 	tx, err := p.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return nil, classify("begin tx", err)
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
-	_, err = tx.Exec(ctx, `
-		INSERT INTO orders(order_uid, track_number, entry, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, raw_json)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-		ON CONFLICT (order_uid) DO UPDATE SET
-		  track_number=EXCLUDED.track_number, entry=EXCLUDED.entry, locale=EXCLUDED.locale,
-		  internal_signature=EXCLUDED.internal_signature, customer_id=EXCLUDED.customer_id,
-		  delivery_service=EXCLUDED.delivery_service, shardkey=EXCLUDED.shardkey, sm_id=EXCLUDED.sm_id,
-		  date_created=EXCLUDED.date_created, oof_shard=EXCLUDED.oof_shard, raw_json=EXCLUDED.raw_json
-	`, o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerID, o.DeliveryService, o.ShardKey, o.SmID, o.DateCreated, o.OofShard, raw)
+	if err := markProcessed(ctx, tx, writes, res); err != nil {
+		return nil, err
+	}
+	stored, err := lockVersions(ctx, tx, uids)
 	if err != nil {
-		return fmt.Errorf("upsert order: %w", err)
+		return nil, err
 	}
 
-	_, err = tx.Exec(ctx, `
-		INSERT INTO deliveries(order_uid, name, phone, zip, city, address, region, email)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
-		ON CONFLICT (order_uid) DO UPDATE SET
-		  name=EXCLUDED.name, phone=EXCLUDED.phone, zip=EXCLUDED.zip, city=EXCLUDED.city,
-		  address=EXCLUDED.address, region=EXCLUDED.region, email=EXCLUDED.email
-	`, o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.ZIP, o.Delivery.City, o.Delivery.Address, o.Delivery.Region, o.Delivery.Email)
-	if err != nil {
-		return fmt.Errorf("upsert delivery: %w", err)
+	b := &pgx.Batch{}
+	var (
+		ops     []string
+		applied []string
+		items   [][]any
+	)
+	for _, i := range newest(writes, res) {
+		o, src := writes[i].Order, writes[i].Source
+		raw, err := json.Marshal(o)
+		if err != nil {
+			return nil, errs.E(errs.KindPermanent, "marshal order", err)
+		}
+		hash, err := o.Hash()
+		if err != nil {
+			return nil, errs.E(errs.KindPermanent, "hash order", err)
+		}
+		if v, ok := stored[o.OrderUID]; ok {
+			if create {
+				return nil, ErrExists
+			}
+			if res[i] = v.outcome(src); res[i] != OutcomeApplied {
+				continue
+			}
+			if v.hash == hash {
+				res[i] = OutcomeUnchanged
+				b.Queue(`UPDATE orders SET source_version=$2, source_ref=$3 WHERE order_uid=$1`, o.OrderUID, src.Version, src.Ref)
+				ops = append(ops, "update source version")
+				continue
+			}
+		}
+		b.Queue(upsertOrderSQL, o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerID, o.DeliveryService, o.ShardKey, o.SmID, o.DateCreated, o.OofShard, raw, src.Version, src.Ref, src.Kind, hash, src.Caller)
+		b.Queue(upsertDeliverySQL, o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.ZIP, o.Delivery.City, o.Delivery.Address, o.Delivery.Region, o.Delivery.Email)
+		b.Queue(upsertPaymentSQL, o.OrderUID, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider, o.Payment.Amount, o.Payment.PaymentDT, o.Payment.Bank, o.Payment.DeliveryCost, o.Payment.GoodsTotal, o.Payment.CustomFee)
+		p.queueNotify(b, Change{OrderUID: o.OrderUID, Op: OpUpsert, TrackNumber: o.TrackNumber, Transaction: o.Payment.Transaction})
+		ops = append(ops, "upsert order", "upsert delivery", "upsert payment", "notify")
+		applied = append(applied, o.OrderUID)
+		for _, it := range o.Items {
+			items = append(items, []any{o.OrderUID, it.ChrtID, it.TrackNumber, it.Price, it.RID, it.Name, it.Sale, it.Size, it.TotalPrice, it.NMID, it.Brand, it.Status})
+		}
+	}
+	if len(applied) > 0 {
+		b.Queue(`DELETE FROM items WHERE order_uid = ANY($1)`, applied)
+		ops = append(ops, "clear items")
 	}
 
-	_, err = tx.Exec(ctx, `
-		INSERT INTO payments(order_uid, transaction, request_id, currency, provider, amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
-		ON CONFLICT (order_uid) DO UPDATE SET
-		  transaction=EXCLUDED.transaction, request_id=EXCLUDED.request_id, currency=EXCLUDED.currency, provider=EXCLUDED.provider,
-		  amount=EXCLUDED.amount, payment_dt=EXCLUDED.payment_dt, bank=EXCLUDED.bank, delivery_cost=EXCLUDED.delivery_cost,
-		  goods_total=EXCLUDED.goods_total, custom_fee=EXCLUDED.custom_fee
-	`, o.OrderUID, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider, o.Payment.Amount, o.Payment.PaymentDT, o.Payment.Bank, o.Payment.DeliveryCost, o.Payment.GoodsTotal, o.Payment.CustomFee)
-	if err != nil {
-		return fmt.Errorf("upsert payment: %w", err)
+	if len(ops) > 0 {
+		if err := execBatch(ctx, tx, b, ops); err != nil {
+			return nil, err
+		}
 	}
 
-	_, err = tx.Exec(ctx, `DELETE FROM items WHERE order_uid=$1`, o.OrderUID)
-	if err != nil {
-		return fmt.Errorf("clear items: %w", err)
+	if len(items) > 0 {
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"items"}, itemColumns, pgx.CopyFromRows(items)); err != nil {
+			return nil, classify("copy items", err)
+		}
+	}
+
+	// Commit even if nothing was written: processed messages are recorded.
+	if err = tx.Commit(ctx); err != nil {
+		return nil, classify("commit", err)
 	}
-	for _, it := range o.Items {
-		if itemErrors := it.Validate(); len(itemErrors) > 0 {
-			return fmt.Errorf("%w: item validation failed: %v", ErrValidation, itemErrors)
+	for _, r := range res {
+		if r != OutcomeApplied {
+			p.m.WriteSkipped(r.String())
 		}
+	}
+	return res, nil
+}
 
-		_, err = tx.Exec(ctx, `
-			INSERT INTO items(order_uid, chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-		`, o.OrderUID, it.ChrtID, it.TrackNumber, it.Price, it.RID, it.Name, it.Sale, it.Size, it.TotalPrice, it.NMID, it.Brand, it.Status)
-		if err != nil {
-			return fmt.Errorf("insert item: %w", err)
+func execBatch(ctx context.Context, tx pgx.Tx, b *pgx.Batch, ops []string) error {
+	br := tx.SendBatch(ctx, b)
+	for _, op := range ops {
+		if _, err := br.Exec(); err != nil {
+			_ = br.Close()
+			return classify(op, err)
 		}
 	}
+	return classify("close batch", br.Close())
+}
+
+// DeleteOrder removes the order; deliveries, payments and items go with it
+// through ON DELETE CASCADE. The deletion is recorded in the history as a
+// version without an order.
+func (p *PG) DeleteOrder(ctx context.Context, uid string, src Source) (err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("delete_order", start, err) }(time.Now())
+	src = withVersion(src)
+
+	tx, err := p.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return classify("begin tx", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
+	if _, err := lockVersions(ctx, tx, []string{uid}); err != nil {
+		return err
+	}
+	tag, err := tx.Exec(ctx, `
+		WITH d AS (DELETE FROM orders WHERE order_uid=$1 RETURNING order_uid, version), ev AS (
+		  INSERT INTO order_events(event_type, order_uid, version, payload)
+		  SELECT '`+EventOrderDeleted+`', order_uid, version+1, jsonb_build_object('order_uid', order_uid)
+		  FROM d
+		)
+		INSERT INTO order_history(order_uid, version, op, source_kind, source_ref, source_version, source_caller)
+		SELECT order_uid, version+1, '`+HistoryDeleted+`', $2, $3, $4, $5
+		FROM d
+	`, uid, src.Kind, src.Ref, src.Version, src.Caller)
+	if err != nil {
+		return classify("delete order", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	b := &pgx.Batch{}
+	p.queueNotify(b, Change{OrderUID: uid, Op: OpDelete})
+	if err := execBatch(ctx, tx, b, []string{"notify"}); err != nil {
+		return err
+	}
 	if err = tx.Commit(ctx); err != nil {
-		return fmt.Errorf("commit: %w", err)
+		return classify("commit", err)
 	}
 	return nil
 }
 
+// OrderExists reports whether an order with the given uid is stored.
+func (p *PG) OrderExists(ctx context.Context, uid string) (ok bool, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("order_exists", start, err) }(time.Now())
+
+	err = p.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE order_uid=$1)`, uid).Scan(&ok)
+	return ok, classify("order exists", err)
+}
+
 func (p *PG) ValidateOrder(ctx context.Context, o *model.Order) error {
 	if validationErrors := o.Validate(); len(validationErrors) > 0 {
-		return fmt.Errorf("%w: %v", ErrValidation, validationErrors)
+		return fmt.Errorf("%w: %w", ErrValidation, model.ValidationErrors(validationErrors))
 	}
 	return nil
 }
 
-func (p *PG) UpsertOrderIfValid(ctx context.Context, o *model.Order) error {
+func (p *PG) UpsertOrderIfValid(ctx context.Context, o *model.Order, src Source) (Outcome, error) {
 	if err := p.ValidateOrder(ctx, o); err != nil {
-		return err
+		return 0, err
 	}
-	return p.UpsertOrder(ctx, o)
+	return p.UpsertOrder(ctx, o, src)
 }
 
 type OrderFull struct {
 	Order model.Order
 }
 
-func (p *PG) GetOrder(ctx context.Context, uid string) (*model.Order, error) {
+func (p *PG) GetOrder(ctx context.Context, uid string) (_ *model.Order, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("get_order", start, err) }(time.Now())
+
 	row := p.db.QueryRow(ctx, `
 		SELECT raw_json
 		FROM orders
 		WHERE order_uid=$1
 	`, uid)
+	return scanOrder(row)
+}
+
+// GetOrderByTrack returns the newest order with the given track number.
+func (p *PG) GetOrderByTrack(ctx context.Context, track string) (_ *model.Order, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("get_order_by_track", start, err) }(time.Now())
+
+	row := p.db.QueryRow(ctx, `
+		SELECT raw_json
+		FROM orders
+		WHERE track_number=$1
+		ORDER BY date_created DESC
+		LIMIT 1
+	`, track)
+	return scanOrder(row)
+}
+
+// GetOrderByTransaction returns the newest order paid with the given
+// payment transaction.
+func (p *PG) GetOrderByTransaction(ctx context.Context, transaction string) (_ *model.Order, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("get_order_by_transaction", start, err) }(time.Now())
+
+	row := p.db.QueryRow(ctx, `
+		SELECT o.raw_json
+		FROM orders o
+		JOIN payments p ON p.order_uid = o.order_uid
+		WHERE p.transaction=$1
+		ORDER BY o.date_created DESC
+		LIMIT 1
+	`, transaction)
+	return scanOrder(row)
+}
+
+func scanOrder(row pgx.Row) (*model.Order, error) {
 	var raw []byte
 	if err := row.Scan(&raw); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrNotFound
-		}
-		if errors.Is(err, context.Canceled) {
-			return nil, err
-		}
-		return nil, fmt.Errorf("scan order: %w", err)
+		return nil, classify("scan order", err)
 	}
 	var o model.Order
 	if err := json.Unmarshal(raw, &o); err != nil {
-		return nil, fmt.Errorf("unmarshal raw_json: %w", err)
+		return nil, errs.E(errs.KindPermanent, "unmarshal raw_json", err)
 	}
 
 	if validationErrors := o.Validate(); len(validationErrors) > 0 {
-		return nil, fmt.Errorf("corrupted data in DB: %w: %v", ErrValidation, validationErrors)
+		return nil, errs.E(errs.KindPermanent, "corrupted data in DB", model.ValidationErrors(validationErrors))
 	}
 
 	return &o, nil
 }
 
-func (p *PG) LoadRecent(ctx context.Context, limit int) ([]*model.Order, error) {
+func (p *PG) LoadRecent(ctx context.Context, limit int) (_ []*model.Order, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("load_recent", start, err) }(time.Now())
+
 	rows, err := p.db.Query(ctx, `
 		SELECT raw_json
 		FROM orders
@@ -149,7 +358,7 @@ func (p *PG) LoadRecent(ctx context.Context, limit int) ([]*model.Order, error)
 		LIMIT $1
 	`, limit)
 	if err != nil {
-		return nil, fmt.Errorf("load recent: %w", err)
+		return nil, classify("load recent", err)
 	}
 	defer rows.Close()
 
@@ -157,15 +366,18 @@ func (p *PG) LoadRecent(ctx context.Context, limit int) ([]*model.Order, error)
 	for rows.Next() {
 		var raw []byte
 		if err := rows.Scan(&raw); err != nil {
-			return nil, fmt.Errorf("scan: %w", err)
+			return nil, classify("scan", err)
 		}
 		var o model.Order
 		if err := json.Unmarshal(raw, &o); err != nil {
-			return nil, fmt.Errorf("unmarshal: %w", err)
+			return nil, errs.E(errs.KindPermanent, "unmarshal", err)
 		}
 
 		if validationErrors := o.Validate(); len(validationErrors) > 0 {
-			fmt.Printf("Warning: skipping invalid order %s: %v\n", o.OrderUID, validationErrors)
+			log.FromContext(ctx).Warn("skipping invalid order",
+				zap.String("order_uid", o.OrderUID),
+				zap.Any("errors", validationErrors),
+			)
 			continue
 		}
 
@@ -173,12 +385,92 @@ func (p *PG) LoadRecent(ctx context.Context, limit int) ([]*model.Order, error)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows error: %w", err)
+		return nil, classify("rows error", err)
 	}
 
 	return res, nil
 }
 
+// IterateRecent streams up to limit orders, newest first, in chunks of
+// chunkSize, calling fn once per chunk. Each chunk is a separate keyset
+// query, so neither the result nor a single query grows with limit. Invalid
+// orders are skipped and don't count towards limit.
+func (p *PG) IterateRecent(ctx context.Context, limit, chunkSize int, fn func([]*model.Order) error) error {
+	if chunkSize <= 0 {
+		chunkSize = 500
+	}
+	var after *cursor
+	for loaded := 0; loaded < limit; {
+		chunk, next, err := p.recentChunk(ctx, after, min(chunkSize, limit-loaded))
+		if err != nil {
+			return err
+		}
+		if len(chunk) > 0 {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			loaded += len(chunk)
+		}
+		if next == nil {
+			return nil
+		}
+		after = next
+	}
+	return nil
+}
+
+// recentChunk reads up to n orders older than after, or the newest ones if
+// after is nil. next is nil once the table is exhausted.
+func (p *PG) recentChunk(ctx context.Context, after *cursor, n int) (_ []*model.Order, next *cursor, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("recent_chunk", start, err) }(time.Now())
+
+	q := `SELECT raw_json, date_created, order_uid FROM orders ORDER BY date_created DESC, order_uid DESC LIMIT $1`
+	args := []any{n}
+	if after != nil {
+		q = `SELECT raw_json, date_created, order_uid FROM orders
+			WHERE (date_created, order_uid) < ($2, $3)
+			ORDER BY date_created DESC, order_uid DESC LIMIT $1`
+		args = append(args, after.DateCreated, after.OrderUID)
+	}
+	rows, err := p.db.Query(ctx, q, args...)
+	if err != nil {
+		return nil, nil, classify("recent chunk", err)
+	}
+	defer rows.Close()
+
+	var (
+		res  []*model.Order
+		read int
+		last cursor
+	)
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw, &last.DateCreated, &last.OrderUID); err != nil {
+			return nil, nil, classify("scan", err)
+		}
+		read++
+		var o model.Order
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return nil, nil, errs.E(errs.KindPermanent, "unmarshal", err)
+		}
+		if validationErrors := o.Validate(); len(validationErrors) > 0 {
+			log.FromContext(ctx).Warn("skipping invalid order",
+				zap.String("order_uid", o.OrderUID),
+				zap.Any("errors", validationErrors),
+			)
+			continue
+		}
+		res = append(res, &o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, classify("rows error", err)
+	}
+	if read < n {
+		return res, nil, nil
+	}
+	return res, &last, nil
+}
+
 func (p *PG) LoadRecentValid(ctx context.Context, limit int) ([]*model.Order, error) {
 	orders, err := p.LoadRecent(ctx, limit)
 	if err != nil {
@@ -0,0 +1,190 @@
+package repo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"wb-snilez-l0/internal/errs"
+	"wb-snilez-l0/internal/model"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// OrderFilter selects orders for SearchOrders. Zero fields don't filter.
+type OrderFilter struct {
+	CustomerID      string
+	TrackNumber     string
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	DeliveryService string
+	PaymentProvider string
+	PaymentCurrency string
+	ItemNMID        int64
+	ItemBrand       string
+	ItemStatus      int
+
+	// Asc sorts by date_created ascending; the default is newest first.
+	Asc    bool
+	Limit  int
+	Cursor string
+}
+
+type OrderPage struct {
+	Orders     []*model.Order `json:"orders"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// cursor is the keyset position after the last returned row.
+type cursor struct {
+	DateCreated time.Time
+	OrderUID    string
+}
+
+func (c cursor) encode() string {
+	s := strconv.FormatInt(c.DateCreated.UnixNano(), 10) + "|" + c.OrderUID
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, errs.E(errs.KindValidation, "decode cursor", err)
+	}
+	ts, uid, ok := strings.Cut(string(b), "|")
+	if !ok {
+		return cursor{}, errs.New(errs.KindValidation, "malformed cursor")
+	}
+	ns, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return cursor{}, errs.E(errs.KindValidation, "decode cursor", err)
+	}
+	return cursor{DateCreated: time.Unix(0, ns).UTC(), OrderUID: uid}, nil
+}
+
+// SearchOrders returns one page of orders matching f, paginated by
+// (date_created, order_uid) keyset.
+func (p *PG) SearchOrders(ctx context.Context, f OrderFilter) (_ OrderPage, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("search_orders", start, err) }(time.Now())
+
+	if f.Limit <= 0 {
+		f.Limit = DefaultPageSize
+	}
+	if f.Limit > MaxPageSize {
+		f.Limit = MaxPageSize
+	}
+
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if f.CustomerID != "" {
+		where = append(where, "o.customer_id = "+arg(f.CustomerID))
+	}
+	if f.TrackNumber != "" {
+		where = append(where, "o.track_number = "+arg(f.TrackNumber))
+	}
+	if !f.CreatedFrom.IsZero() {
+		where = append(where, "o.date_created >= "+arg(f.CreatedFrom))
+	}
+	if !f.CreatedTo.IsZero() {
+		where = append(where, "o.date_created < "+arg(f.CreatedTo))
+	}
+	if f.DeliveryService != "" {
+		where = append(where, "o.delivery_service = "+arg(f.DeliveryService))
+	}
+
+	var pay []string
+	if f.PaymentProvider != "" {
+		pay = append(pay, "pm.provider = "+arg(f.PaymentProvider))
+	}
+	if f.PaymentCurrency != "" {
+		pay = append(pay, "pm.currency = "+arg(f.PaymentCurrency))
+	}
+	if len(pay) > 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM payments pm WHERE pm.order_uid = o.order_uid AND "+strings.Join(pay, " AND ")+")")
+	}
+
+	var item []string
+	if f.ItemNMID != 0 {
+		item = append(item, "i.nm_id = "+arg(f.ItemNMID))
+	}
+	if f.ItemBrand != "" {
+		item = append(item, "i.brand = "+arg(f.ItemBrand))
+	}
+	if f.ItemStatus != 0 {
+		item = append(item, "i.status = "+arg(f.ItemStatus))
+	}
+	if len(item) > 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND "+strings.Join(item, " AND ")+")")
+	}
+
+	dir, cmp := "DESC", "<"
+	if f.Asc {
+		dir, cmp = "ASC", ">"
+	}
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return OrderPage{}, err
+		}
+		where = append(where, fmt.Sprintf("(o.date_created, o.order_uid) %s (%s, %s)", cmp, arg(c.DateCreated), arg(c.OrderUID)))
+	}
+
+	q := "SELECT o.raw_json, o.date_created FROM orders o"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += fmt.Sprintf(" ORDER BY o.date_created %s, o.order_uid %s LIMIT %s", dir, dir, arg(f.Limit+1))
+
+	rows, err := p.db.Query(ctx, q, args...)
+	if err != nil {
+		return OrderPage{}, classify("search orders", err)
+	}
+	defer rows.Close()
+
+	var (
+		page    OrderPage
+		created []time.Time
+	)
+	for rows.Next() {
+		var (
+			raw []byte
+			ts  time.Time
+		)
+		if err := rows.Scan(&raw, &ts); err != nil {
+			return OrderPage{}, classify("scan", err)
+		}
+		var o model.Order
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return OrderPage{}, errs.E(errs.KindPermanent, "unmarshal", err)
+		}
+		page.Orders = append(page.Orders, &o)
+		created = append(created, ts)
+	}
+	if err := rows.Err(); err != nil {
+		return OrderPage{}, classify("rows error", err)
+	}
+
+	if len(page.Orders) > f.Limit {
+		page.Orders = page.Orders[:f.Limit]
+		last := page.Orders[f.Limit-1]
+		page.NextCursor = cursor{DateCreated: created[f.Limit-1], OrderUID: last.OrderUID}.encode()
+	}
+	if page.Orders == nil {
+		page.Orders = []*model.Order{}
+	}
+	return page, nil
+}
@@ -0,0 +1,244 @@
+package repo
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"wb-snilez-l0/internal/model"
+)
+
+// Source kinds.
+const (
+	SourceKafka = "kafka"
+	SourceAPI   = "api"
+)
+
+// Source describes where a write came from. Version orders the writes of one
+// order: a write older than the stored one is refused. Ref identifies the
+// write itself, e.g. the Kafka topic/partition/offset, so that a redelivery
+// is told apart from a different write with the same version.
+type Source struct {
+	Kind    string `json:"kind"`
+	Ref     string `json:"ref"`
+	Version int64  `json:"version"`
+	// Caller identifies who made an API write.
+	Caller string `json:"caller,omitempty"`
+	// Message is set for writes from Kafka; UpsertOrders records it in
+	// processed_messages and ignores it when it is delivered again.
+	Message *Message `json:"-"`
+}
+
+// Message identifies a Kafka message.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+}
+
+// Write is an order together with its source.
+type Write struct {
+	Order  *model.Order
+	Source Source
+}
+
+// Outcome of a write.
+type Outcome int
+
+const (
+	// OutcomeApplied: the order was stored.
+	OutcomeApplied Outcome = iota
+	// OutcomeStale: a newer version is stored; the write was dropped.
+	OutcomeStale
+	// OutcomeDuplicate: this very write is stored already.
+	OutcomeDuplicate
+	// OutcomeUnchanged: the stored order has the same content; only its
+	// source version was updated.
+	OutcomeUnchanged
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeApplied:
+		return "applied"
+	case OutcomeStale:
+		return "stale"
+	case OutcomeDuplicate:
+		return "duplicate"
+	case OutcomeUnchanged:
+		return "unchanged"
+	default:
+		return "unknown"
+	}
+}
+
+type storedVersion struct {
+	version int64
+	ref     string
+	hash    string
+}
+
+// outcome classifies src against the stored version of the order.
+func (v storedVersion) outcome(src Source) Outcome {
+	switch {
+	case src.Version < v.version:
+		return OutcomeStale
+	case src.Version == v.version && src.Ref == v.ref:
+		return OutcomeDuplicate
+	default:
+		return OutcomeApplied
+	}
+}
+
+// superseded classifies a write dropped in favour of a newer one in the
+// same batch.
+func superseded(src, by Source) Outcome {
+	if src.Version == by.Version && src.Ref == by.Ref {
+		return OutcomeDuplicate
+	}
+	return OutcomeStale
+}
+
+// newest picks the newest write per order_uid among those res still
+// reports applied; on a version tie the later write wins. The others are
+// classified against it and reported in res, which holds one slot per write.
+// It returns the indexes of the winners in the order of their first
+// occurrence.
+func newest(writes []Write, res []Outcome) []int {
+	seen := make(map[string]int, len(writes))
+	var idx []int
+	for i, w := range writes {
+		if res[i] != OutcomeApplied {
+			continue
+		}
+		j, ok := seen[w.Order.OrderUID]
+		if !ok {
+			seen[w.Order.OrderUID] = len(idx)
+			idx = append(idx, i)
+			continue
+		}
+		cur := writes[idx[j]].Source
+		if w.Source.Version < cur.Version {
+			res[i] = superseded(w.Source, cur)
+			continue
+		}
+		res[idx[j]] = superseded(cur, w.Source)
+		idx[j] = i
+	}
+	return idx
+}
+
+// lockVersions serializes writers of the given orders until tx ends and
+// returns their stored versions. Advisory locks cover orders that don't
+// exist yet, which row locks can't; they are taken in uid order so that
+// concurrent batches don't deadlock.
+func lockVersions(ctx context.Context, tx pgx.Tx, uids []string) (map[string]storedVersion, error) {
+	sorted := slices.Clone(uids)
+	slices.Sort(sorted)
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended(uid, 0)) FROM unnest($1::text[]) AS uid`, sorted); err != nil {
+		return nil, classify("lock orders", err)
+	}
+
+	rows, err := tx.Query(ctx, `SELECT order_uid, source_version, source_ref, content_hash FROM orders WHERE order_uid = ANY($1)`, uids)
+	if err != nil {
+		return nil, classify("select versions", err)
+	}
+	defer rows.Close()
+	res := make(map[string]storedVersion, len(uids))
+	for rows.Next() {
+		var (
+			uid string
+			v   storedVersion
+		)
+		if err := rows.Scan(&uid, &v.version, &v.ref, &v.hash); err != nil {
+			return nil, classify("scan version", err)
+		}
+		res[uid] = v
+	}
+	return res, classify("select versions", rows.Err())
+}
+
+// markProcessed records the Kafka messages of writes in processed_messages
+// and reports the writes whose message was recorded before as duplicates.
+// Messages are inserted in a fixed order so that concurrent batches don't
+// deadlock.
+func markProcessed(ctx context.Context, tx pgx.Tx, writes []Write, res []Outcome) error {
+	var idx []int
+	for i, w := range writes {
+		if w.Source.Message != nil {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 {
+		return nil
+	}
+	slices.SortFunc(idx, func(a, b int) int {
+		ma, mb := writes[a].Source.Message, writes[b].Source.Message
+		if c := strings.Compare(ma.Topic, mb.Topic); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(ma.Partition, mb.Partition); c != 0 {
+			return c
+		}
+		return cmp.Compare(ma.Offset, mb.Offset)
+	})
+	topics := make([]string, len(idx))
+	partitions := make([]int32, len(idx))
+	offsets := make([]int64, len(idx))
+	for j, i := range idx {
+		m := writes[i].Source.Message
+		topics[j], partitions[j], offsets[j] = m.Topic, int32(m.Partition), m.Offset
+	}
+
+	rows, err := tx.Query(ctx, `
+		INSERT INTO processed_messages(topic, partition, "offset")
+		SELECT * FROM unnest($1::text[], $2::int[], $3::bigint[])
+		ON CONFLICT DO NOTHING
+		RETURNING topic, partition, "offset"
+	`, topics, partitions, offsets)
+	if err != nil {
+		return classify("mark processed", err)
+	}
+	defer rows.Close()
+	fresh := make(map[Message]bool, len(idx))
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.Topic, &m.Partition, &m.Offset); err != nil {
+			return classify("scan processed", err)
+		}
+		fresh[m] = true
+	}
+	if err := rows.Err(); err != nil {
+		return classify("mark processed", err)
+	}
+	for _, i := range idx {
+		if !fresh[*writes[i].Source.Message] {
+			res[i] = OutcomeDuplicate
+		}
+	}
+	return nil
+}
+
+// withVersion fills in a missing source version with the current time.
+func withVersion(src Source) Source {
+	if src.Version == 0 {
+		src.Version = time.Now().UnixNano()
+	}
+	return src
+}
+
+// DeleteProcessedMessages forgets the Kafka messages processed before the
+// given time; a redelivery of one of them is then caught by the content hash.
+func (p *PG) DeleteProcessedMessages(ctx context.Context, before time.Time) (n int64, err error) {
+	defer func(start time.Time) { p.m.ObserveQuery("delete_processed_messages", start, err) }(time.Now())
+
+	tag, err := p.db.Exec(ctx, `DELETE FROM processed_messages WHERE processed_at < $1`, before)
+	if err != nil {
+		return 0, classify("delete processed messages", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -4,51 +4,463 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 
 	"wb-snilez-l0/internal/cache"
+	"wb-snilez-l0/internal/errs"
+	"wb-snilez-l0/internal/log"
+	"wb-snilez-l0/internal/metrics"
 	"wb-snilez-l0/internal/model"
 	"wb-snilez-l0/internal/repo"
 )
 
 type Service struct {
-	repo  *repo.PG
-	cache *cache.LRU[string, *model.Order]
+	repo    *repo.PG
+	cache   cache.Cache[string, *model.Order]
+	byTrack *cache.Index[string, *model.Order]
+	byTx    *cache.Index[string, *model.Order]
+
+	warm          atomic.Bool
+	warmTarget    atomic.Int64
+	warmLoaded    atomic.Int64
+	warmupChunk   int
+	readyFraction float64
+
+	// loads coalesces concurrent cache-miss loads of the same key; missing
+	// remembers keys the store recently reported as not found.
+	loads   singleflight.Group
+	missing *cache.LRU[string, struct{}]
+
+	m            *metrics.Metrics
+	refreshAhead time.Duration
 }
 
-func New(r *repo.PG, c *cache.LRU[string, *model.Order]) *Service {
-	return &Service{repo: r, cache: c}
+type Config struct {
+	// TTL of the secondary index entries.
+	TTL time.Duration
+	// NegativeTTL is how long a not-found lookup is remembered; 0 disables
+	// negative caching.
+	NegativeTTL time.Duration
+	// RefreshAhead reloads an order in the background when it is read less
+	// than this long before its expiry; 0 disables it. Orders read after
+	// expiry but within the cache's grace period are served stale and
+	// reloaded the same way.
+	RefreshAhead time.Duration
+	// WarmupChunk is the number of orders Warmup reads per query.
+	WarmupChunk int
+	// ReadyFraction is the share of the warmup target after which CheckWarm
+	// reports the service ready; 0 waits for the warmup to finish.
+	ReadyFraction float64
 }
 
-func (s *Service) Put(ctx context.Context, o *model.Order) error {
-	if err := s.repo.UpsertOrder(ctx, o); err != nil {
+func New(r *repo.PG, c cache.Cache[string, *model.Order], m *metrics.Metrics, cfg Config) *Service {
+	capacity := c.Stats().Capacity
+	s := &Service{
+		repo:         r,
+		cache:        c,
+		m:            m,
+		refreshAhead: cfg.RefreshAhead,
+
+		warmupChunk:   cfg.WarmupChunk,
+		readyFraction: cfg.ReadyFraction,
+		byTrack:       cache.NewIndex(c, func(o *model.Order) string { return o.TrackNumber }, capacity, cfg.TTL),
+		byTx:          cache.NewIndex(c, func(o *model.Order) string { return o.Payment.Transaction }, capacity, cfg.TTL),
+	}
+	if cfg.NegativeTTL > 0 {
+		s.missing = cache.NewLRU[string, struct{}](capacity, cfg.NegativeTTL)
+	}
+	return s
+}
+
+// TrackIndexStats and TransactionIndexStats expose the secondary index caches.
+func (s *Service) TrackIndexStats() cache.Stats       { return s.byTrack.Stats() }
+func (s *Service) TransactionIndexStats() cache.Stats { return s.byTx.Stats() }
+
+// NegativeStats exposes the not-found cache.
+func (s *Service) NegativeStats() cache.Stats {
+	if s.missing == nil {
+		return cache.Stats{}
+	}
+	return s.missing.Stats()
+}
+
+// Keys of loads and not-found entries, one namespace per lookup.
+func uidKey(uid string) string     { return "uid:" + uid }
+func trackKey(track string) string { return "track:" + track }
+func txKey(tx string) string       { return "tx:" + tx }
+
+// setCache stores o in the cache and its secondary indexes and forgets any
+// earlier not-found result for its keys. Writes through the service bypass
+// the admission policy: a just written order is likely to be read soon.
+func (s *Service) setCache(o *model.Order, writeThrough bool) {
+	if writeThrough {
+		s.cache.Put(o.OrderUID, o)
+	} else {
+		s.cache.Set(o.OrderUID, o)
+	}
+	s.byTrack.Add(o.OrderUID, o)
+	s.byTx.Add(o.OrderUID, o)
+	if s.missing != nil {
+		s.missing.Delete(uidKey(o.OrderUID))
+		s.missing.Delete(trackKey(o.TrackNumber))
+		s.missing.Delete(txKey(o.Payment.Transaction))
+	}
+}
+
+// load runs fetch for key at most once at a time, caches the result and
+// shares it with all callers waiting on the same key. Not-found results are
+// remembered for the negative TTL.
+//
+// The fetch runs detached from the caller's cancellation so that one client
+// going away doesn't fail the load for everyone else, bounded by loadTimeout
+// so that a hung query doesn't hold the key; each caller still stops waiting
+// when its own context is done.
+func (s *Service) load(ctx context.Context, key string, fetch func(context.Context) (*model.Order, error)) (*model.Order, error) {
+	if s.missing != nil {
+		if _, ok := s.missing.Get(key); ok {
+			return nil, repo.ErrNotFound
+		}
+	}
+
+	ch := s.loads.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		o, err := fetch(ctx)
+		if err != nil {
+			if s.missing != nil && errors.Is(err, repo.ErrNotFound) {
+				s.missing.Set(key, struct{}{})
+			}
+			return nil, err
+		}
+		s.setCache(o, false)
+		return o, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*model.Order), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Put stores o unless a newer or the same write of it is stored already, as
+// told by src; the cache is updated only if the write was applied.
+func (s *Service) Put(ctx context.Context, o *model.Order, src repo.Source) (repo.Outcome, error) {
+	res, err := s.repo.UpsertOrder(ctx, o, src)
+	if err != nil {
+		return 0, err
+	}
+	if res == repo.OutcomeApplied {
+		s.setCache(o, true)
+	}
+	return res, nil
+}
+
+var (
+	ErrExists = repo.ErrExists
+	// ErrStale reports a write refused because a newer one is stored.
+	ErrStale = errs.New(errs.KindConflict, "a newer version of the order is stored")
+)
+
+// Create stores a new order and fails with ErrExists if the uid is taken.
+func (s *Service) Create(ctx context.Context, o *model.Order, src repo.Source) error {
+	if err := s.repo.CreateOrder(ctx, o, src); err != nil {
 		return err
 	}
-	s.cache.Set(o.OrderUID, o) // write-through
+	s.setCache(o, true)
 	return nil
 }
 
+// Delete removes the order from the store and evicts it from the cache.
+func (s *Service) Delete(ctx context.Context, uid string, src repo.Source) error {
+	if err := s.repo.DeleteOrder(ctx, uid, src); err != nil {
+		return err
+	}
+	s.cache.Delete(uid)
+	log.FromContext(ctx).Info("order deleted", zap.String("order_uid", uid))
+	return nil
+}
+
+// PutResult is the result of one write of PutBatch.
+type PutResult struct {
+	Outcome repo.Outcome
+	Err     error
+}
+
+// PutBatch stores orders in as few transactions as possible and returns one
+// result per write. A batch that fails with a non-retryable error is split
+// in halves until the offending orders are isolated.
+func (s *Service) PutBatch(ctx context.Context, writes []repo.Write) []PutResult {
+	res := make([]PutResult, len(writes))
+	s.putBatch(ctx, writes, res)
+	return res
+}
+
+func (s *Service) putBatch(ctx context.Context, writes []repo.Write, res []PutResult) {
+	if len(writes) == 0 {
+		return
+	}
+	outcomes, err := s.repo.UpsertOrders(ctx, writes)
+	if err == nil {
+		for i, w := range writes {
+			res[i].Outcome = outcomes[i]
+			if outcomes[i] == repo.OutcomeApplied {
+				s.setCache(w.Order, true)
+			}
+		}
+		return
+	}
+	if len(writes) == 1 || errs.KindOf(err).ConsumerAction() == errs.ActionRetry {
+		for i := range res {
+			res[i].Err = err
+		}
+		return
+	}
+	mid := len(writes) / 2
+	s.putBatch(ctx, writes[:mid], res[:mid])
+	s.putBatch(ctx, writes[mid:], res[mid:])
+}
+
 func (s *Service) Get(ctx context.Context, uid string) (*model.Order, error) {
-	if o, ok := s.cache.Get(uid); ok {
+	if o, expiresAt, ok := s.cache.GetEntry(uid); ok {
+		switch left := time.Until(expiresAt); {
+		case left < 0:
+			s.refresh(ctx, uid, "stale")
+		case left < s.refreshAhead:
+			s.refresh(ctx, uid, "ahead")
+		}
 		return o, nil
 	}
+	return s.load(ctx, uidKey(uid), func(ctx context.Context) (*model.Order, error) {
+		return s.fetch(ctx, uid)
+	})
+}
+
+func (s *Service) fetch(ctx context.Context, uid string) (*model.Order, error) {
 	o, err := s.repo.GetOrder(ctx, uid)
-	if err != nil {
-		if errors.Is(err, repo.ErrNotFound) {
-			return nil, err
-		}
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
 		return nil, fmt.Errorf("repo: %w", err)
 	}
-	s.cache.Set(uid, o)
-	return o, nil
+	return o, err
+}
+
+// loadTimeout bounds a shared cache-miss load, refreshTimeout a background
+// reload.
+const (
+	loadTimeout    = 5 * time.Second
+	refreshTimeout = 5 * time.Second
+)
+
+// refresh reloads uid in the background unless a load of it is already in
+// flight. An order that is gone from the store is dropped from the cache.
+func (s *Service) refresh(ctx context.Context, uid, trigger string) {
+	s.loads.DoChan(uidKey(uid), func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+		defer cancel()
+
+		o, err := s.fetch(ctx, uid)
+		s.m.CacheRefreshed(trigger, err)
+		switch {
+		case err == nil:
+			s.setCache(o, false)
+		case errors.Is(err, repo.ErrNotFound):
+			s.cache.Delete(uid)
+		default:
+			log.FromContext(ctx).Warn("cache refresh failed",
+				zap.String("order_uid", uid),
+				zap.String("trigger", trigger),
+				zap.Error(err),
+			)
+		}
+		return o, err
+	})
+}
+
+// GetByTrack returns the order with the given track number.
+func (s *Service) GetByTrack(ctx context.Context, track string) (*model.Order, error) {
+	if o, ok := s.byTrack.Get(track); ok {
+		return o, nil
+	}
+	return s.load(ctx, trackKey(track), func(ctx context.Context) (*model.Order, error) {
+		return s.repo.GetOrderByTrack(ctx, track)
+	})
+}
+
+// GetByTransaction returns the order paid with the given transaction.
+func (s *Service) GetByTransaction(ctx context.Context, transaction string) (*model.Order, error) {
+	if o, ok := s.byTx.Get(transaction); ok {
+		return o, nil
+	}
+	return s.load(ctx, txKey(transaction), func(ctx context.Context) (*model.Order, error) {
+		return s.repo.GetOrderByTransaction(ctx, transaction)
+	})
+}
+
+// ListByCustomer pages through a customer's orders, newest first.
+func (s *Service) ListByCustomer(ctx context.Context, customerID string, limit int, cursor string) (repo.OrderPage, error) {
+	return s.repo.SearchOrders(ctx, repo.OrderFilter{CustomerID: customerID, Limit: limit, Cursor: cursor})
+}
+
+// Search lists orders matching f straight from the store; pages are not cached.
+func (s *Service) Search(ctx context.Context, f repo.OrderFilter) (repo.OrderPage, error) {
+	return s.repo.SearchOrders(ctx, f)
+}
+
+// ForgetProcessed drops the record of Kafka messages processed before the
+// given time.
+func (s *Service) ForgetProcessed(ctx context.Context, before time.Time) (int64, error) {
+	return s.repo.DeleteProcessedMessages(ctx, before)
+}
+
+// HistoryEntry is a version of an order with the fields changed since the
+// version before it.
+type HistoryEntry struct {
+	repo.Revision
+	Changes []model.FieldChange `json:"changes,omitempty"`
+}
+
+// History lists the versions of an order, oldest first, straight from the
+// store. Entries carry the changes but not the order itself; see Revision.
+func (s *Service) History(ctx context.Context, uid string) ([]HistoryEntry, error) {
+	revs, err := s.repo.OrderHistory(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]HistoryEntry, len(revs))
+	var prev *model.Order
+	for i, r := range revs {
+		res[i] = HistoryEntry{Revision: r, Changes: model.Diff(prev, r.Order)}
+		res[i].Order = nil
+		prev = r.Order
+	}
+	return res, nil
 }
 
+// Revision returns one version of an order with its changes.
+func (s *Service) Revision(ctx context.Context, uid string, version int64) (HistoryEntry, error) {
+	r, prev, err := s.repo.OrderRevision(ctx, uid, version)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	return HistoryEntry{Revision: r, Changes: model.Diff(prev, r.Order)}, nil
+}
+
+// Warmup fills the cache with up to n of the most recent orders, streaming
+// them from the store in chunks. Orders cached meanwhile by reads or the
+// consumer are not overwritten. The service reports itself warm once Warmup
+// returns, even on error: the cache then fills on demand.
 func (s *Service) Warmup(ctx context.Context, n int) error {
-	orders, err := s.repo.LoadRecent(ctx, n)
+	defer s.warm.Store(true)
+	s.warmTarget.Store(int64(n))
+
+	l := log.FromContext(ctx)
+	start := time.Now()
+	err := s.repo.IterateRecent(ctx, n, s.warmupChunk, func(orders []*model.Order) error {
+		var added int64
+		for _, o := range orders {
+			if s.cache.Add(o.OrderUID, o) {
+				s.byTrack.Add(o.OrderUID, o)
+				s.byTx.Add(o.OrderUID, o)
+				added++
+			}
+		}
+		loaded := s.warmLoaded.Add(added)
+		l.Debug("cache warmup progress", zap.Int64("orders", loaded), zap.Int("target", n))
+		return nil
+	})
+	l.Info("cache warmed up",
+		zap.Int64("orders", s.warmLoaded.Load()),
+		zap.Duration("duration", time.Since(start)),
+	)
+	return err
+}
+
+// WarmupProgress returns the share of the warmup target loaded so far, 1 once
+// the cache is warm.
+func (s *Service) WarmupProgress() float64 {
+	if s.warm.Load() {
+		return 1
+	}
+	target := s.warmTarget.Load()
+	if target <= 0 {
+		return 0
+	}
+	return min(1, float64(s.warmLoaded.Load())/float64(target))
+}
+
+// ListenChanges evicts orders written by other instances from the cache
+// until ctx is done; the next read loads the new version.
+func (s *Service) ListenChanges(ctx context.Context) error {
+	return s.repo.ListenChanges(ctx, func(c repo.Change) {
+		s.cache.Delete(c.OrderUID)
+		if s.missing != nil {
+			s.missing.Delete(uidKey(c.OrderUID))
+			if c.TrackNumber != "" {
+				s.missing.Delete(trackKey(c.TrackNumber))
+			}
+			if c.Transaction != "" {
+				s.missing.Delete(txKey(c.Transaction))
+			}
+		}
+		s.m.CacheInvalidated(c.Op, c.Time())
+	})
+}
+
+// SaveSnapshot writes the cached orders to path for LoadSnapshot to pick up
+// on the next start.
+func (s *Service) SaveSnapshot(ctx context.Context, path string) error {
+	n, err := cache.WriteSnapshot(path, s.cache)
 	if err != nil {
 		return err
 	}
-	for _, o := range orders {
-		s.cache.Set(o.OrderUID, o)
+	log.FromContext(ctx).Info("cache snapshot saved", zap.String("path", path), zap.Int("orders", n))
+	return nil
+}
+
+// LoadSnapshot fills the cache from a snapshot no older than maxAge. On
+// success the service is warm; on error the caller should fall back to
+// Warmup.
+func (s *Service) LoadSnapshot(ctx context.Context, path string, maxAge time.Duration) error {
+	entries, created, err := cache.ReadSnapshot[string, *model.Order](path, maxAge)
+	if err != nil {
+		return err
 	}
+	s.cache.Restore(entries)
+	for _, e := range entries {
+		s.byTrack.Add(e.Key, e.Value)
+		s.byTx.Add(e.Key, e.Value)
+	}
+	s.warm.Store(true)
+	log.FromContext(ctx).Info("cache restored from snapshot",
+		zap.String("path", path),
+		zap.Int("orders", len(entries)),
+		zap.Time("created", created),
+	)
 	return nil
 }
+
+// Ping checks the store behind the service.
+func (s *Service) Ping(ctx context.Context) error {
+	return s.repo.Ping(ctx)
+}
+
+// CheckWarm returns an error until the cache warmup has completed or reached
+// the ready fraction.
+func (s *Service) CheckWarm(ctx context.Context) error {
+	if s.warm.Load() {
+		return nil
+	}
+	p := s.WarmupProgress()
+	if s.readyFraction > 0 && p >= s.readyFraction {
+		return nil
+	}
+	return fmt.Errorf("cache warmup in progress: %.0f%%", p*100)
+}
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS items_status_idx;
+DROP INDEX IF EXISTS items_brand_idx;
+DROP INDEX IF EXISTS items_nm_id_idx;
+DROP INDEX IF EXISTS payments_provider_currency_idx;
+DROP INDEX IF EXISTS orders_delivery_service_date_idx;
+DROP INDEX IF EXISTS orders_track_number_idx;
+DROP INDEX IF EXISTS orders_customer_date_idx;
+DROP INDEX IF EXISTS orders_date_created_uid_idx;
@@ -0,0 +1,10 @@
+CREATE INDEX IF NOT EXISTS orders_date_created_uid_idx ON orders(date_created, order_uid);
+CREATE INDEX IF NOT EXISTS orders_customer_date_idx ON orders(customer_id, date_created, order_uid);
+CREATE INDEX IF NOT EXISTS orders_track_number_idx ON orders(track_number);
+CREATE INDEX IF NOT EXISTS orders_delivery_service_date_idx ON orders(delivery_service, date_created, order_uid);
+
+CREATE INDEX IF NOT EXISTS payments_provider_currency_idx ON payments(provider, currency);
+
+CREATE INDEX IF NOT EXISTS items_nm_id_idx ON items(nm_id);
+CREATE INDEX IF NOT EXISTS items_brand_idx ON items(brand);
+CREATE INDEX IF NOT EXISTS items_status_idx ON items(status);
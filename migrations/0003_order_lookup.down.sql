@@ -0,0 +1 @@
+DROP INDEX IF EXISTS payments_transaction_idx;
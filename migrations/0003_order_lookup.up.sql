@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS payments_transaction_idx ON payments(transaction);
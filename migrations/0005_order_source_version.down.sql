@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS source_ref;
+ALTER TABLE orders DROP COLUMN IF EXISTS source_version;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS order_history (
+    order_uid TEXT NOT NULL,
+    version BIGINT NOT NULL,
+    op TEXT NOT NULL,
+    raw_json JSONB,
+    source_kind TEXT NOT NULL DEFAULT '',
+    source_ref TEXT NOT NULL DEFAULT '',
+    source_version BIGINT NOT NULL DEFAULT 0,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (order_uid, version)
+);
+
+-- Orders written before the history existed start it with their current state.
+INSERT INTO order_history(order_uid, version, op, raw_json, source_ref, source_version)
+SELECT order_uid, version, 'updated', raw_json, source_ref, source_version FROM orders
+ON CONFLICT DO NOTHING;
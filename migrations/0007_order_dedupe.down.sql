@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS processed_messages;
+ALTER TABLE orders DROP COLUMN IF EXISTS content_hash;
@@ -0,0 +1 @@
+ALTER TABLE order_history ADD COLUMN IF NOT EXISTS source_caller TEXT NOT NULL DEFAULT '';